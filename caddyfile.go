@@ -1,7 +1,6 @@
 package pages
 
 import (
-	"fmt"
 	"github.com/alecthomas/units"
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
@@ -19,32 +18,36 @@ import (
 )
 
 func init() {
-	middle := &Middleware{
-		Config: &pages.MiddlewareConfig{},
-	}
-	caddy.RegisterModule(middle)
-	httpcaddyfile.RegisterHandlerDirective("gitea", parseCaddyfile(middle))
+	caddy.RegisterModule(new(Middleware))
+	httpcaddyfile.RegisterHandlerDirective("gitea", parseCaddyfile)
 }
 
 func (m *Middleware) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if m.Config == nil {
+		m.Config = &pages.MiddlewareConfig{}
+	}
 	for d.Next() {
 		for n := d.Nesting(); d.NextBlock(n); {
 			switch d.Val() {
 			case "server":
 				d.Args(&m.Config.Server)
 			case "token":
-				d.Args(&m.Config.Token)
+				remainingArgs := d.RemainingArgs()
+				if len(remainingArgs) == 0 {
+					return d.ArgErr()
+				}
+				m.Config.Token = remainingArgs[0]
+				m.Config.Tokens = remainingArgs[1:]
 			case "cache":
 				remainingArgs := d.RemainingArgs()
 				if len(remainingArgs) != 3 {
 					return d.Errf("expected 3 argument for 'cache'; got %v", remainingArgs)
 				}
-				var err error
-				m.Config.CacheTimeout, err = time.ParseDuration(remainingArgs[0])
+				timeout, err := caddy.ParseDuration(remainingArgs[0])
 				if err != nil {
 					return d.Errf("invalid duration: %v", err)
 				}
-				m.Config.CacheRefresh, err = time.ParseDuration(remainingArgs[1])
+				refresh, err := caddy.ParseDuration(remainingArgs[1])
 				if err != nil {
 					return d.Errf("invalid duration: %v", err)
 				}
@@ -52,7 +55,9 @@ func (m *Middleware) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				if err != nil {
 					return d.Errf("invalid CacheSize: %v", err)
 				}
-				m.Config.CacheMaxSize = int(size)
+				m.Config.CacheTimeout = caddy.Duration(timeout)
+				m.Config.CacheRefresh = caddy.Duration(refresh)
+				m.Config.CacheMaxSize = pages.ByteSize(size)
 			case "domain":
 				d.Args(&m.Config.Domain)
 			case "alias":
@@ -101,20 +106,157 @@ func (m *Middleware) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					}
 					m.Config.ErrorPages[strings.ToLower(args[0])] = body
 				}
-			case "redirect":
+			case "debug_errors":
+				m.Config.DebugErrors = true
+			case "error_repo":
+				args := d.RemainingArgs()
+				if len(args) < 1 || len(args) > 2 {
+					return d.ArgErr()
+				}
+				owner, repo, found := strings.Cut(args[0], "/")
+				if !found || owner == "" || repo == "" {
+					return d.Errf("expected owner/repo, got '%s'", args[0])
+				}
+				m.Config.ErrorRepo = pages.NewPageDomain(owner, repo, "")
+				if len(args) == 2 {
+					m.Config.ErrorRepo.Branch = args[1]
+				}
+			case "shared_cache":
+				remainingArgs := d.RemainingArgs()
+				if len(remainingArgs) > 1 {
+					return d.Errf("expected at most 1 argument for 'shared_cache'; got %v", remainingArgs)
+				}
+				m.Config.SharedCache = true
+				if len(remainingArgs) == 1 {
+					m.Config.SharedPrefix = remainingArgs[0]
+				}
+			case "webhook":
 				remainingArgs := d.RemainingArgs()
 				if len(remainingArgs) != 2 {
-					return d.Errf("expected 2 arguments, got %d", len(remainingArgs))
+					return d.Errf("expected path and secret for 'webhook'; got %v", remainingArgs)
 				}
-				code, err := strconv.Atoi(remainingArgs[1])
+				if !strings.HasPrefix(remainingArgs[0], "/") {
+					return d.Errf("webhook path must start with '/'; got %s", remainingArgs[0])
+				}
+				m.Config.Webhook = &pages.Webhook{
+					Path:   remainingArgs[0],
+					Secret: remainingArgs[1],
+				}
+			case "health":
+				remainingArgs := d.RemainingArgs()
+				if len(remainingArgs) > 1 {
+					return d.Errf("expected at most 1 argument for 'health'; got %v", remainingArgs)
+				}
+				m.Config.HealthPath = "/.gitea-pages"
+				if len(remainingArgs) == 1 {
+					if !strings.HasPrefix(remainingArgs[0], "/") {
+						return d.Errf("health path must start with '/'; got %s", remainingArgs[0])
+					}
+					m.Config.HealthPath = remainingArgs[0]
+				}
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					switch d.Val() {
+					case "host":
+						m.Config.HealthHosts = append(m.Config.HealthHosts, d.RemainingArgs()...)
+					case "allow":
+						m.Config.HealthAllow = append(m.Config.HealthAllow, d.RemainingArgs()...)
+					default:
+						return d.Errf("unknown health subdirective '%s'", d.Val())
+					}
+				}
+			case "branch":
+				if !d.Args(&m.Config.DefaultBranch) {
+					return d.ArgErr()
+				}
+			case "private":
+				if !d.Args(&m.Config.PrivatePolicy) {
+					return d.ArgErr()
+				}
+			case "lookup":
+				if !d.Args(&m.Config.RepoLookup) {
+					return d.ArgErr()
+				}
+			case "autoindex":
+				var template string
+				if !d.Args(&template) {
+					return d.ArgErr()
+				}
+				body, err := parseBody(template)
+				if err != nil {
+					return d.Errf("failed to parse autoindex template: %v", err)
+				}
+				m.Config.AutoIndex = body
+			case "markdown":
+				var template string
+				if !d.Args(&template) {
+					return d.ArgErr()
+				}
+				body, err := parseBody(template)
 				if err != nil {
-					return d.WrapErr(err)
+					return d.Errf("failed to parse markdown template: %v", err)
 				}
-				m.Config.AutoRedirect = &pages.AutoRedirect{
-					Enabled: true,
-					Scheme:  remainingArgs[0],
-					Code:    code,
+				m.Config.Markdown = body
+			case "index":
+				m.Config.IndexFiles = d.RemainingArgs()
+				if len(m.Config.IndexFiles) == 0 {
+					return d.ArgErr()
+				}
+			case "proxy_allow":
+				m.Config.ProxyAllow = d.RemainingArgs()
+				if len(m.Config.ProxyAllow) == 0 {
+					return d.ArgErr()
+				}
+			case "not_found":
+				if !d.Args(&m.Config.NotFoundFile) {
+					return d.ArgErr()
+				}
+			case "clean_urls":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				m.Config.CleanURLs = true
+			case "trailing_slash":
+				if !d.Args(&m.Config.TrailingSlash) {
+					return d.ArgErr()
+				}
+			case "canonical_redirect":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				m.Config.Canonical = true
+			case "negative_cache":
+				remainingArgs := d.RemainingArgs()
+				if len(remainingArgs) == 0 || len(remainingArgs) > 2 {
+					return d.Errf("expected 1 or 2 arguments for 'negative_cache'; got %v", remainingArgs)
+				}
+				ttl, err := caddy.ParseDuration(remainingArgs[0])
+				if err != nil {
+					return d.Errf("invalid duration: %v", err)
+				}
+				m.Config.NegativeCache = &pages.NegativeCacheConfig{TTL: caddy.Duration(ttl)}
+				if len(remainingArgs) == 2 {
+					if m.Config.NegativeCache.Size, err = strconv.Atoi(remainingArgs[1]); err != nil {
+						return d.Errf("invalid negative cache size: %v", err)
+					}
+				}
+			case "rate_limit":
+				rateLimit, err := parseRateLimit(d)
+				if err != nil {
+					return err
 				}
+				m.Config.RateLimit = rateLimit
+			case "site":
+				site, err := parseSite(d)
+				if err != nil {
+					return err
+				}
+				m.Config.Sites = append(m.Config.Sites, site)
+			case "redirect":
+				redirect, err := parseRedirect(d)
+				if err != nil {
+					return err
+				}
+				m.Config.AutoRedirect = redirect
 			default:
 				return d.Errf("unrecognized subdirective '%s'", d.Val())
 			}
@@ -123,6 +265,121 @@ func (m *Middleware) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	return nil
 }
 
+func parseRedirect(d *caddyfile.Dispenser) (*pages.AutoRedirect, error) {
+	remainingArgs := d.RemainingArgs()
+	if len(remainingArgs) != 2 {
+		return nil, d.Errf("expected 2 arguments, got %d", len(remainingArgs))
+	}
+	code, err := strconv.Atoi(remainingArgs[1])
+	if err != nil {
+		return nil, d.WrapErr(err)
+	}
+	return &pages.AutoRedirect{
+		Enabled: true,
+		Scheme:  remainingArgs[0],
+		Code:    code,
+	}, nil
+}
+
+// parseSite 解析额外的 Pages 根域名
+//
+//	site docs.example.com {
+//	    server https://git.example.com
+//	    token please-replace-it
+//	    branch pages
+//	    redirect https 302
+//	}
+func parseSite(d *caddyfile.Dispenser) (*pages.SiteConfig, error) {
+	site := &pages.SiteConfig{}
+	if !d.Args(&site.Domain) {
+		return nil, d.ArgErr()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "server":
+			if !d.Args(&site.Server) {
+				return nil, d.ArgErr()
+			}
+		case "token":
+			remainingArgs := d.RemainingArgs()
+			if len(remainingArgs) == 0 {
+				return nil, d.ArgErr()
+			}
+			site.Token = remainingArgs[0]
+			site.Tokens = remainingArgs[1:]
+		case "branch":
+			if !d.Args(&site.DefaultBranch) {
+				return nil, d.ArgErr()
+			}
+		case "redirect":
+			redirect, err := parseRedirect(d)
+			if err != nil {
+				return nil, err
+			}
+			site.AutoRedirect = redirect
+		case "private":
+			if !d.Args(&site.PrivatePolicy) {
+				return nil, d.ArgErr()
+			}
+		default:
+			return nil, d.Errf("unrecognized site subdirective '%s'", d.Val())
+		}
+	}
+	return site, nil
+}
+
+// parseRateLimit 解析限流配置
+//
+//	rate_limit {
+//	    client 10 20
+//	    owner 2 5
+//	    concurrency 16
+//	}
+func parseRateLimit(d *caddyfile.Dispenser) (*pages.RateLimitConfig, error) {
+	if d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	result := &pages.RateLimitConfig{}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "client", "owner":
+			name := d.Val()
+			remainingArgs := d.RemainingArgs()
+			if len(remainingArgs) == 0 || len(remainingArgs) > 2 {
+				return nil, d.Errf("expected 1 or 2 arguments for '%s'; got %v", name, remainingArgs)
+			}
+			limit := &pages.RateLimit{}
+			var err error
+			if limit.Rate, err = strconv.ParseFloat(remainingArgs[0], 64); err != nil {
+				return nil, d.Errf("invalid rate: %v", err)
+			}
+			if len(remainingArgs) == 2 {
+				if limit.Burst, err = strconv.Atoi(remainingArgs[1]); err != nil {
+					return nil, d.Errf("invalid burst: %v", err)
+				}
+			}
+			if name == "client" {
+				result.Client = limit
+			} else {
+				result.Owner = limit
+			}
+		case "concurrency":
+			var value string
+			if !d.Args(&value) {
+				return nil, d.ArgErr()
+			}
+			concurrency, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, d.Errf("invalid concurrency: %v", err)
+			}
+			result.Concurrency = concurrency
+		default:
+			return nil, d.Errf("unrecognized rate_limit subdirective '%s'", d.Val())
+		}
+	}
+	return result, nil
+}
+
 func parseBody(path string) (string, error) {
 	fileData, err := os.ReadFile(path)
 	if err == nil {
@@ -146,34 +403,14 @@ func parseBody(path string) (string, error) {
 	return "", err
 }
 
-func parseCaddyfile(middleware *Middleware) func(httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
-	return func(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
-		err := middleware.UnmarshalCaddyfile(h.Dispenser)
-		if err != nil {
-			return nil, err
-		}
-		if middleware.Config.ErrorPages == nil {
-			middleware.Config.ErrorPages = make(map[string]string)
-		}
-		if middleware.Config.CustomHeaders == nil {
-			middleware.Config.CustomHeaders = make(map[string]string)
-		}
-		if middleware.Config.AutoRedirect == nil {
-			middleware.Config.AutoRedirect = &pages.AutoRedirect{
-				Enabled: false,
-			}
-		}
-		if middleware.Config.CacheRefresh <= 0 {
-			middleware.Config.CacheRefresh = 1 * time.Minute
-		}
-		if middleware.Config.CacheTimeout <= 0 {
-			middleware.Config.CacheTimeout = 3 * time.Minute
-		}
-		if middleware.Config.CacheMaxSize <= 0 {
-			middleware.Config.CacheMaxSize = 3 * 1024 * 1024
-		}
-		return middleware, nil
+// parseCaddyfile 每个 gitea 块创建独立的实例
+func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	middleware := new(Middleware)
+	err := middleware.UnmarshalCaddyfile(h.Dispenser)
+	if err != nil {
+		return nil, err
 	}
+	return middleware, nil
 }
 
 type Middleware struct {
@@ -187,20 +424,13 @@ func (m *Middleware) ServeHTTP(
 	request *http.Request,
 	handler caddyhttp.Handler,
 ) error {
-
-	type stackTracer interface {
-		StackTrace() errors.StackTrace
-	}
 	err := m.Client.Route(writer, request)
 	if errors.Is(err, pages.ErrorNotMatches) {
 		return handler.ServeHTTP(writer, request)
 	} else {
 		if err != nil {
-			if err, ok := err.(stackTracer); ok {
-				for _, f := range err.StackTrace() {
-					fmt.Printf("%+s:%d\n", f, f)
-				}
-			}
+			m.Logger.Error("failed to serve page", zap.String("host", request.Host),
+				zap.String("path", request.RequestURI), zap.Error(err), pages.StackField(err))
 		}
 		return err
 	}
@@ -216,6 +446,9 @@ func (m *Middleware) CaddyModule() caddy.ModuleInfo {
 }
 
 func (m *Middleware) Validate() error {
+	if err := m.Config.Validate(); err != nil {
+		return err
+	}
 	return m.Client.Validate()
 }
 
@@ -227,14 +460,42 @@ func (m *Middleware) Cleanup() error {
 func (m *Middleware) Provision(ctx caddy.Context) error {
 	var err error
 	m.Logger = ctx.Logger() // g.Logger is a *zap.Logger
+	if m.Config == nil {
+		m.Config = &pages.MiddlewareConfig{}
+	}
+	if m.Config.ErrorPages == nil {
+		m.Config.ErrorPages = make(map[string]string)
+	}
+	if m.Config.CustomHeaders == nil {
+		m.Config.CustomHeaders = make(map[string]string)
+	}
+	if m.Config.AutoRedirect == nil {
+		m.Config.AutoRedirect = &pages.AutoRedirect{
+			Enabled: false,
+		}
+	}
+	if m.Config.CacheRefresh <= 0 {
+		m.Config.CacheRefresh = caddy.Duration(1 * time.Minute)
+	}
+	if m.Config.CacheTimeout <= 0 {
+		m.Config.CacheTimeout = caddy.Duration(3 * time.Minute)
+	}
+	if m.Config.CacheMaxSize <= 0 {
+		m.Config.CacheMaxSize = 3 * 1024 * 1024
+	}
+	var storage pages.SharedStorage
+	if m.Config.SharedCache {
+		storage = ctx.Storage()
+	}
 	m.Client, err = pages.NewPageClient(
 		m.Config,
+		storage,
 		m.Logger,
 	)
 	if err != nil {
 		return err
 	}
-	return nil
+	return m.Client.RegisterMetrics(ctx.GetMetricsRegistry())
 }
 
 var (
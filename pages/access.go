@@ -0,0 +1,163 @@
+package pages
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/patrickmn/go-cache"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"strings"
+)
+
+// htpasswdTopic 使用仓库根目录的 .htpasswd 保护页面
+const (
+	htpasswdTopic = "pages-htpasswd"
+	htpasswdFile  = "/.htpasswd"
+)
+
+// AccessConfig 仓库级别的访问控制，来自 .pages.yaml 或仓库标签
+type AccessConfig struct {
+	Htpasswd string   `yaml:"htpasswd" json:"htpasswd,omitempty"` // 仓库内的 htpasswd 文件，仅支持 bcrypt
+	Allow    []string `yaml:"allow" json:"allow,omitempty"`       // 允许访问的 IP 或 CIDR
+	Realm    string   `yaml:"realm" json:"realm,omitempty"`
+
+	Users map[string]string `yaml:"-" json:"users,omitempty"` // 用户名与 bcrypt 哈希
+}
+
+// loadAccess 读取访问控制配置与 htpasswd 文件
+func loadAccess(ctx context.Context, client *GiteaConfig, domain *PageDomain, repoConfig *RepoConfig, result *DomainConfig) error {
+	access := repoConfig.Access
+	if access == nil && result.Topics[htpasswdTopic] {
+		access = &AccessConfig{}
+	}
+	if access == nil {
+		result.Access = nil
+		return nil
+	}
+	if access.Htpasswd == "" && result.Topics[htpasswdTopic] {
+		access.Htpasswd = htpasswdFile
+	}
+	if access.Htpasswd != "" {
+		access.Htpasswd = path.Clean("/" + access.Htpasswd)
+		data, err := client.ReadRepoFile(ctx, domain, result.BasePath+access.Htpasswd)
+		if err != nil && !errors.Is(err, ErrorNotFound) {
+			return err
+		}
+		// 文件不存在时不允许任何用户登录
+		access.Users = parseHtpasswd(data, client.Logger)
+	}
+	result.Access = access
+	return nil
+}
+
+func parseHtpasswd(data []byte, logger *zap.Logger) map[string]string {
+	result := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, found := strings.Cut(line, ":")
+		if !found || user == "" {
+			continue
+		}
+		if !strings.HasPrefix(hash, "$2a$") && !strings.HasPrefix(hash, "$2b$") && !strings.HasPrefix(hash, "$2y$") {
+			logger.Warn("htpasswd entry is not bcrypt, ignored.", zap.String("user", user))
+			continue
+		}
+		result[user] = hash
+	}
+	return result
+}
+
+// checkAccess 校验来源 IP 与 htpasswd 凭据，均配置时需同时满足
+func (s *PageSite) checkAccess(
+	writer http.ResponseWriter,
+	request *http.Request,
+	domain *PageDomain,
+	config *DomainConfig,
+	filePath string,
+) error {
+	access := config.Access
+	if access == nil {
+		return nil
+	}
+	if access.Htpasswd != "" && path.Clean("/"+filePath) == access.Htpasswd {
+		return errors.Wrap(ErrorNotFound, "htpasswd file is not served")
+	}
+	writer.Header().Set("Cache-Control", "private")
+	if len(access.Allow) > 0 && !access.allowed(clientIP(request)) {
+		return errors.Wrap(ErrorForbidden, "client address not allowed")
+	}
+	if access.Htpasswd == "" {
+		return nil
+	}
+	realm := access.Realm
+	if realm == "" {
+		realm = domain.Owner + "/" + domain.Repo
+	}
+	username, password, ok := request.BasicAuth()
+	if !ok {
+		challenge(writer, realm)
+		return errors.Wrap(ErrorUnauthorized, "credentials required")
+	}
+	if !s.checkPassword(config, username, password) {
+		challenge(writer, realm)
+		return errors.Wrap(ErrorUnauthorized, "invalid credentials")
+	}
+	return nil
+}
+
+// checkPassword bcrypt 校验较慢，结果按提交缓存
+func (s *PageSite) checkPassword(config *DomainConfig, username, password string) bool {
+	key := fmt.Sprintf("htpasswd|%x", sha256.Sum256([]byte(
+		username+"\x00"+password+"\x00"+config.PageDomain.Key()+"\x00"+config.SHA)))
+	if result, ok := s.visitors.Get(key); ok {
+		return result.(bool)
+	}
+	hash, ok := config.Access.Users[username]
+	result := ok && bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	s.visitors.Set(key, result, cache.DefaultExpiration)
+	return result
+}
+
+func (a *AccessConfig) allowed(addr netip.Addr) bool {
+	return addressAllowed(a.Allow, addr)
+}
+
+// addressAllowed 地址是否在 IP 或 CIDR 列表中
+func addressAllowed(allow []string, addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, item := range allow {
+		if prefix, err := netip.ParsePrefix(item); err == nil {
+			if prefix.Contains(addr) {
+				return true
+			}
+		} else if ip, err := netip.ParseAddr(item); err == nil && ip.Unmap() == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP 优先使用 Caddy 根据 trusted_proxies 解析的客户端地址
+func clientIP(request *http.Request) netip.Addr {
+	address, _ := caddyhttp.GetVar(request.Context(), caddyhttp.ClientIPVarKey).(string)
+	if address == "" {
+		address, _, _ = net.SplitHostPort(request.RemoteAddr)
+	}
+	addr, _ := netip.ParseAddr(address)
+	return addr
+}
@@ -0,0 +1,83 @@
+package pages
+
+import (
+	"go.uber.org/zap"
+	"maps"
+	"net/netip"
+	"testing"
+)
+
+func TestAccessAllowed(t *testing.T) {
+	allow := []string{"10.0.0.0/8", "192.168.1.10", "2001:db8::/32", "not-an-address"}
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"11.0.0.1", false},
+		{"192.168.1.10", true},
+		{"192.168.1.11", false},
+		{"::ffff:10.0.0.1", true},
+		{"::ffff:192.168.1.10", true},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+		{"", false},
+	}
+	access := &AccessConfig{Allow: allow}
+	for _, test := range tests {
+		addr, _ := netip.ParseAddr(test.addr)
+		if got := access.allowed(addr); got != test.want {
+			t.Errorf("allowed(%q) = %v, want %v", test.addr, got, test.want)
+		}
+	}
+	if (&AccessConfig{}).allowed(netip.MustParseAddr("10.0.0.1")) {
+		t.Error("empty allow list must not match")
+	}
+}
+
+func TestParseHtpasswd(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want map[string]string
+	}{
+		{
+			name: "bcrypt variants",
+			data: "a:$2a$10$x\nb:$2b$10$y\nc:$2y$10$z\n",
+			want: map[string]string{"a": "$2a$10$x", "b": "$2b$10$y", "c": "$2y$10$z"},
+		},
+		{
+			name: "comments and blank lines",
+			data: "# users\n\n  a:$2y$10$x  \n",
+			want: map[string]string{"a": "$2y$10$x"},
+		},
+		{
+			name: "unsupported hashes",
+			data: "md5:$apr1$salt$hash\nsha:{SHA}abc\nplain:secret\n",
+			want: map[string]string{},
+		},
+		{
+			name: "malformed lines",
+			data: "no-separator\n:$2y$10$x\nb:\n",
+			want: map[string]string{},
+		},
+		{
+			name: "colon in hash",
+			data: "a:$2y$10$x:y\n",
+			want: map[string]string{"a": "$2y$10$x:y"},
+		},
+		{
+			name: "empty",
+			data: "",
+			want: map[string]string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := parseHtpasswd([]byte(test.data), zap.NewNop())
+			if !maps.Equal(got, test.want) {
+				t.Errorf("parseHtpasswd() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
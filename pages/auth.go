@@ -0,0 +1,87 @@
+package pages
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/patrickmn/go-cache"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+// 私有仓库的访问策略
+const (
+	PrivatePublic = "public" // 使用服务端 token 公开访问 (默认)
+	PrivateAuth   = "auth"   // 访客需要使用 Gitea 账户登录并拥有仓库权限
+	PrivateHide   = "hide"   // 不提供访问
+)
+
+// publicTopic 标记私有仓库的 Pages 公开访问
+const publicTopic = "pages-public"
+
+// restricted 仓库是否需要访问控制
+func (receiver *DomainConfig) restricted() bool {
+	return (receiver.Private || receiver.Internal) && !receiver.Topics[publicTopic]
+}
+
+// authorize 根据站点策略检查访客是否可以访问私有仓库
+func (s *PageSite) authorize(
+	writer http.ResponseWriter,
+	request *http.Request,
+	domain *PageDomain,
+	config *DomainConfig,
+) error {
+	if !config.restricted() {
+		return nil
+	}
+	switch s.PrivatePolicy {
+	case PrivateHide:
+		return errors.Wrap(ErrorNotFound, "private repository hidden")
+	case PrivateAuth:
+		writer.Header().Set("Cache-Control", "private")
+		username, password, ok := request.BasicAuth()
+		if !ok {
+			challenge(writer, domain.Owner+"/"+domain.Repo)
+			return errors.Wrap(ErrorUnauthorized, "credentials required")
+		}
+		status, err := s.checkVisitor(request.Context(), domain, username, password)
+		if err != nil {
+			return err
+		}
+		switch status {
+		case http.StatusOK:
+			return nil
+		case http.StatusUnauthorized:
+			challenge(writer, domain.Owner+"/"+domain.Repo)
+			return errors.Wrap(ErrorUnauthorized, "invalid credentials")
+		default:
+			return errors.Wrap(ErrorForbidden, "repository not accessible")
+		}
+	default:
+		return nil
+	}
+}
+
+// checkVisitor 访客的校验结果会缓存一段时间，避免每次请求都访问 Gitea
+func (s *PageSite) checkVisitor(ctx context.Context, domain *PageDomain, username, password string) (int, error) {
+	key := fmt.Sprintf("%x", sha256.Sum256([]byte(username+"\x00"+password+"\x00"+domain.Owner+"/"+domain.Repo)))
+	if status, ok := s.visitors.Get(key); ok {
+		return status.(int), nil
+	}
+	status, err := s.GiteaConfig.CheckAccess(ctx, domain, username, password)
+	if err != nil {
+		return 0, err
+	}
+	switch status {
+	case http.StatusOK, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+	default:
+		// Gitea 异常时不缓存，避免访客在缓存期间一直被拒绝
+		return 0, upstreamError(status, errors.Errorf("unexpected status code '%d'", status))
+	}
+	s.visitors.Set(key, status, cache.DefaultExpiration)
+	return status, nil
+}
+
+func challenge(writer http.ResponseWriter, realm string) {
+	writer.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, realm))
+}
@@ -0,0 +1,107 @@
+package pages
+
+import (
+	"github.com/patrickmn/go-cache"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthorize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		username, password, _ := request.BasicAuth()
+		switch {
+		case password == "broken":
+			writer.WriteHeader(http.StatusInternalServerError)
+		case password != "secret":
+			writer.WriteHeader(http.StatusUnauthorized)
+		case username == "alice":
+			writer.WriteHeader(http.StatusOK)
+		default:
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	private := &DomainConfig{Private: true, Topics: map[string]bool{}}
+	tests := []struct {
+		name      string
+		policy    string
+		config    *DomainConfig
+		username  string
+		password  string
+		want      int // 0 表示允许访问
+		challenge bool
+	}{
+		{"public without credentials", PrivatePublic, private, "", "", 0, false},
+		{"public with invalid credentials", PrivatePublic, private, "alice", "wrong", 0, false},
+		{"default policy", "", private, "", "", 0, false},
+		{"hide without credentials", PrivateHide, private, "", "", http.StatusNotFound, false},
+		{"hide with valid credentials", PrivateHide, private, "alice", "secret", http.StatusNotFound, false},
+		{"hide with invalid credentials", PrivateHide, private, "alice", "wrong", http.StatusNotFound, false},
+		{"auth without credentials", PrivateAuth, private, "", "", http.StatusUnauthorized, true},
+		{"auth with valid credentials", PrivateAuth, private, "alice", "secret", 0, false},
+		{"auth with invalid credentials", PrivateAuth, private, "alice", "wrong", http.StatusUnauthorized, true},
+		{"auth without repository access", PrivateAuth, private, "bob", "secret", http.StatusForbidden, false},
+		{"auth when gitea fails", PrivateAuth, private, "alice", "broken", http.StatusBadGateway, false},
+		{"auth on public repository", PrivateAuth, &DomainConfig{Topics: map[string]bool{}}, "", "", 0, false},
+		{"auth on internal repository", PrivateAuth, &DomainConfig{Internal: true, Topics: map[string]bool{}}, "", "", http.StatusUnauthorized, true},
+		{"auth with public topic", PrivateAuth, &DomainConfig{Private: true, Topics: map[string]bool{publicTopic: true}}, "", "", 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			site := &PageSite{
+				PrivatePolicy: test.policy,
+				GiteaConfig:   &GiteaConfig{Server: server.URL},
+				visitors:      cache.New(time.Minute, time.Minute),
+			}
+			request := httptest.NewRequest(http.MethodGet, "https://alice.example.com/blog/", nil)
+			if test.username != "" {
+				request.SetBasicAuth(test.username, test.password)
+			}
+			writer := httptest.NewRecorder()
+			err := site.authorize(writer, request, NewPageDomain("alice", "blog", "gh-pages"), test.config)
+			got := 0
+			if err != nil {
+				got = errorStatus(err)
+			}
+			if got != test.want {
+				t.Errorf("authorize() = %v (%d), want %d", err, got, test.want)
+			}
+			if challenged := writer.Header().Get("WWW-Authenticate") != ""; challenged != test.challenge {
+				t.Errorf("challenge = %v, want %v", challenged, test.challenge)
+			}
+		})
+	}
+}
+
+func TestCheckVisitorCache(t *testing.T) {
+	calls := 0
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		calls++
+		writer.WriteHeader(status)
+	}))
+	defer server.Close()
+	site := &PageSite{GiteaConfig: &GiteaConfig{Server: server.URL}, visitors: cache.New(time.Minute, time.Minute)}
+	domain := NewPageDomain("alice", "blog", "gh-pages")
+	tests := []struct {
+		username string
+		status   int
+		calls    int
+	}{
+		{"ok", http.StatusOK, 1},
+		{"ok", http.StatusOK, 1}, // 命中缓存
+		{"denied", http.StatusForbidden, 2},
+		{"denied", http.StatusForbidden, 2},
+		{"failing", http.StatusServiceUnavailable, 3},
+		{"failing", http.StatusServiceUnavailable, 4}, // 异常状态不缓存
+	}
+	for _, test := range tests {
+		status = test.status
+		_, _ = site.checkVisitor(t.Context(), domain, test.username, "secret")
+		if calls != test.calls {
+			t.Errorf("%s: %d calls to gitea, want %d", test.username, calls, test.calls)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package pages
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"github.com/Masterminds/sprig/v3"
+	"github.com/alecthomas/units"
+	"github.com/patrickmn/go-cache"
+	"github.com/pkg/errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// autoIndexTopic 没有 index.html 的仓库显示目录索引
+const autoIndexTopic = "pages-autoindex"
+
+//go:embed autoindex.gohtml
+var autoIndexPage string
+
+type IndexEntry struct {
+	Name string `json:"name"`
+	Dir  bool   `json:"dir"`
+	Size int64  `json:"size"`
+	URL  string `json:"url"`
+}
+
+type IndexMetadata struct {
+	Domain  *PageDomain   `json:"-"`
+	Path    string        `json:"path"`
+	Sort    string        `json:"sort"`  // name 或 size
+	Order   string        `json:"order"` // asc 或 desc
+	Entries []*IndexEntry `json:"entries"`
+}
+
+// NewIndexTemplate 未指定模板时使用内置模板
+func NewIndexTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = autoIndexPage
+	}
+	return template.New("autoindex").Funcs(sprig.FuncMap()).Funcs(template.FuncMap{
+		"size": func(size int64) string { return units.Base2Bytes(size).String() },
+	}).Parse(text)
+}
+
+// listDirectory 读取目录内容，结果按提交缓存，不是目录时返回 ErrorNotFound
+func (receiver *DomainConfig) listDirectory(ctx context.Context, client *GiteaConfig, dir string) ([]*IndexEntry, error) {
+	key := "autoindex:" + dir
+	if cached, _ := receiver.FileCache.Get(key); cached != nil {
+		data := cached.([]byte)
+		if len(data) == 0 {
+			return nil, ErrorNotFound
+		}
+		var entries []*IndexEntry
+		return entries, json.Unmarshal(data, &entries)
+	}
+	domain := &receiver.PageDomain
+	release, err := client.acquire(ctx, domain.Owner)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	originCtx, done := client.origin(ctx, "ListContents")
+	contents, resp, err := client.api(originCtx).ListContents(domain.Owner, domain.Repo, receiver.SHA,
+		strings.Trim(receiver.BasePath+dir, "/"))
+	done(giteaStatus(resp))
+	if status := giteaStatus(resp); status == http.StatusNotFound || (err != nil && status == http.StatusOK) {
+		// 不存在或者不是目录
+		receiver.FileCache.Set(key, make([]byte, 0), cache.DefaultExpiration)
+		return nil, ErrorNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	entries := make([]*IndexEntry, 0, len(contents))
+	for _, content := range contents {
+		// 隐藏 .pages.yaml、.htpasswd 等文件
+		if strings.HasPrefix(content.Name, ".") || content.Type == "submodule" {
+			continue
+		}
+		entry := &IndexEntry{
+			Name: content.Name,
+			Dir:  content.Type == "dir",
+			Size: content.Size,
+			URL:  url.PathEscape(content.Name),
+		}
+		if entry.Dir {
+			entry.URL += "/"
+		}
+		entries = append(entries, entry)
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return nil, err
+	}
+	receiver.FileCache.Set(key, data, cache.DefaultExpiration)
+	return entries, nil
+}
+
+// autoIndex 渲染目录索引，?sort=name|size&order=asc|desc 排序，?format=json 或 Accept 为 JSON 时返回 JSON
+func (receiver *DomainConfig) autoIndex(
+	ctx context.Context,
+	client *GiteaConfig,
+	filePath string,
+	request *http.Request,
+) (*FakeResponse, error) {
+	dir := strings.TrimSuffix(filePath, "/")
+	entries, err := receiver.listDirectory(ctx, client, dir)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(filePath, "/") {
+		return redirectResponse(path.Base(filePath) + "/"), nil
+	}
+	query := request.URL.Query()
+	metadata := &IndexMetadata{
+		Domain:  &receiver.PageDomain,
+		Path:    dir + "/",
+		Sort:    "name",
+		Order:   "asc",
+		Entries: entries,
+	}
+	if query.Get("sort") == "size" {
+		metadata.Sort = "size"
+	}
+	if query.Get("order") == "desc" {
+		metadata.Order = "desc"
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Dir != entries[j].Dir {
+			// 目录在前
+			return entries[i].Dir
+		}
+		less := entries[i].Name < entries[j].Name
+		if metadata.Sort == "size" && entries[i].Size != entries[j].Size {
+			less = entries[i].Size < entries[j].Size
+		}
+		if metadata.Order == "desc" {
+			return !less
+		}
+		return less
+	})
+	format := "html"
+	accept := request.Header.Get("Accept")
+	if query.Get("format") == "json" ||
+		(strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")) {
+		format = "json"
+	}
+	buf := new(bytes.Buffer)
+	result := receiver.newResponse(client, strings.Join([]string{filePath, metadata.Sort, metadata.Order, format}, "|"))
+	if format == "json" {
+		err = json.NewEncoder(buf).Encode(metadata)
+		result.ContentType("application/json; charset=utf-8")
+	} else {
+		err = client.IndexTemplate.Execute(buf, metadata)
+		result.ContentType("text/html; charset=utf-8")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "render autoindex")
+	}
+	result.SetHeader("Vary", "Accept")
+	result.Body = NewByteBuf(buf.Bytes())
+	result.Length(buf.Len())
+	result.CacheModeIgnore()
+	return result, nil
+}
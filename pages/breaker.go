@@ -0,0 +1,74 @@
+package pages
+
+import (
+	"github.com/pkg/errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// breakerThreshold 连续失败达到该次数后暂停回源
+	breakerThreshold = 5
+	// breakerCooldown 首次暂停的时间，之后每次失败翻倍，最长 breakerMaxCooldown
+	breakerCooldown    = time.Second
+	breakerMaxCooldown = time.Minute
+)
+
+// Breaker Gitea 连续不可用时直接返回 503，避免请求堆积在超时上
+type Breaker struct {
+	failures int
+	until    time.Time
+	mutex    sync.Mutex
+}
+
+type BreakerHealth struct {
+	Open     bool       `json:"open"`
+	Failures int        `json:"failures"`
+	Until    *time.Time `json:"until,omitempty"`
+}
+
+func (b *Breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if time.Now().Before(b.until) {
+		return errors.Wrapf(ErrorUnavailable, "gitea circuit open until %s", b.until.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// record 仅网络错误与网关类状态码计为失败，冷却结束后的请求成功即恢复
+func (b *Breaker) record(resp *http.Response, err error) {
+	if b == nil {
+		return
+	}
+	failed := err != nil || resp.StatusCode == http.StatusBadGateway ||
+		resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !failed {
+		b.failures = 0
+		b.until = time.Time{}
+		return
+	}
+	b.failures++
+	if b.failures >= breakerThreshold {
+		cooldown := breakerCooldown << min(b.failures-breakerThreshold, 6)
+		b.until = time.Now().Add(min(cooldown, breakerMaxCooldown))
+	}
+}
+
+func (b *Breaker) status() BreakerHealth {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	result := BreakerHealth{Failures: b.failures}
+	if time.Now().Before(b.until) {
+		until := b.until
+		result.Open = true
+		result.Until = &until
+	}
+	return result
+}
@@ -0,0 +1,34 @@
+package pages
+
+import (
+	"github.com/pkg/errors"
+	"net/http"
+	"testing"
+)
+
+func TestBreaker(t *testing.T) {
+	breaker := &Breaker{}
+	failure := &http.Response{StatusCode: http.StatusBadGateway}
+	for i := 1; i < breakerThreshold; i++ {
+		breaker.record(failure, nil)
+		if err := breaker.allow(); err != nil {
+			t.Fatalf("breaker opened after %d failures", i)
+		}
+	}
+	breaker.record(nil, errors.New("connection refused"))
+	if err := breaker.allow(); !errors.Is(err, ErrorUnavailable) {
+		t.Fatalf("allow() = %v, want ErrorUnavailable", err)
+	}
+	if status := breaker.status(); !status.Open || status.Failures != breakerThreshold {
+		t.Errorf("status() = %+v, want open with %d failures", status, breakerThreshold)
+	}
+	breaker.record(&http.Response{StatusCode: http.StatusNotFound}, nil)
+	if err := breaker.allow(); err != nil || breaker.status().Failures != 0 {
+		t.Error("a successful response must close the breaker")
+	}
+	var disabled *Breaker
+	disabled.record(failure, nil)
+	if disabled.allow() != nil {
+		t.Error("nil breaker must allow requests")
+	}
+}
@@ -4,13 +4,16 @@ import (
 	"bufio"
 	"bytes"
 	"code.gitea.io/sdk/gitea"
+	"context"
 	"crypto/sha1"
 	"fmt"
 	"github.com/patrickmn/go-cache"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 	"io"
 	"net/http"
+	"path"
 	"slices"
 	"strconv"
 	"strings"
@@ -32,28 +35,49 @@ func (c *DomainCache) Close() error {
 }
 
 type DomainConfig struct {
-	FetchTime int64 //上次刷新时间
+	FetchTime int64 `json:"fetch_time"` //上次刷新时间
 
-	PageDomain PageDomain
-	Exists     bool         // 当前项目是否为 Pages
-	FileCache  *cache.Cache // 文件缓存
+	PageDomain PageDomain   `json:"page_domain"`
+	Exists     bool         `json:"exists"` // 当前项目是否为 Pages
+	FileCache  *cache.Cache `json:"-"`      // 文件缓存
 
-	CNAME    []string        // 重定向地址
-	SHA      string          // 缓存 SHA
-	DATE     time.Time       // 文件提交时间
-	BasePath string          // 根目录
-	Topics   map[string]bool // 存储库标记
+	CNAME    []string        `json:"cname,omitempty"`  // 重定向地址
+	SHA      string          `json:"sha"`              // 缓存 SHA
+	DATE     time.Time       `json:"date"`             // 文件提交时间
+	BasePath string          `json:"base_path"`        // 根目录
+	Topics   map[string]bool `json:"topics,omitempty"` // 存储库标记
+	Private  bool            `json:"private"`          // 私有仓库
+	Internal bool            `json:"internal"`         // 内部仓库
+	Access   *AccessConfig   `json:"access,omitempty"` // 访问控制
 
-	Index    string //默认页面
-	NotFound string //不存在页面
+	RepoConfig *RepoConfig  `json:"repo_config,omitempty"` // 仓库内的 .pages.yaml
+	AutoIndex  bool         `json:"autoindex,omitempty"`   // 目录索引
+	Markdown   bool         `json:"markdown,omitempty"`    // 渲染 Markdown
+	Templates  bool         `json:"templates,omitempty"`   // 渲染 HTML 模板
+	Proxy      []*ProxyRule `json:"proxy,omitempty"`       // 转发到外部的规则
+
+	Index    string `json:"index"`              //默认页面
+	NotFound string `json:"not_found"`          //不存在页面
+	Fallback string `json:"fallback,omitempty"` // history 路由的回退页面
 }
 
 func (receiver *DomainConfig) Close() error {
 	receiver.FileCache.Flush()
 	return nil
 }
-func (receiver *DomainConfig) IsRoutePage() bool {
-	return receiver.Topics["routes-history"] || receiver.Topics["routes-hash"]
+
+// RouteMode SPA 路由模式，.pages.yaml 优先于仓库标签
+func (receiver *DomainConfig) RouteMode() string {
+	if receiver.RepoConfig != nil && receiver.RepoConfig.Routes != "" {
+		return receiver.RepoConfig.Routes
+	}
+	if receiver.Topics["routes-history"] {
+		return RouteHistory
+	}
+	if receiver.Topics["routes-hash"] {
+		return RouteHash
+	}
+	return ""
 }
 
 func NewDomainCache(ttl time.Duration, refreshTtl time.Duration) DomainCache {
@@ -74,29 +98,36 @@ func NewDomainCache(ttl time.Duration, refreshTtl time.Duration) DomainCache {
 	}
 }
 
-func fetch(client *GiteaConfig, domain *PageDomain, result *DomainConfig) error {
-	branches, resp, err := client.Client.ListRepoBranches(domain.Owner, domain.Repo,
-		gitea.ListRepoBranchesOptions{
-			ListOptions: gitea.ListOptions{
-				PageSize: 999,
-			},
+func fetch(ctx context.Context, client *GiteaConfig, domain *PageDomain, result *DomainConfig) (err error) {
+	ctx, span := startSpan(ctx, "pages.fetch", domainAttributes(domain)...)
+	defer func() { endSpan(span, err) }()
+	branches, resp, err := listAll(ctx, client, "ListRepoBranches",
+		func(api *gitea.Client, options gitea.ListOptions) ([]*gitea.Branch, *gitea.Response, error) {
+			return api.ListRepoBranches(domain.Owner, domain.Repo,
+				gitea.ListRepoBranchesOptions{ListOptions: options})
 		})
-	// 缓存 404 内容
-	if resp != nil && resp.StatusCode >= 400 && resp.StatusCode < 500 {
-		result.Exists = false
-		return nil
+	if giteaStatus(resp) == http.StatusNotFound {
+		return errors.Wrap(ErrorNotFound, "repository not found")
+	} else if err != nil {
+		// 401、403、429 等不代表仓库不存在，不写入未命中缓存
+		return upstreamError(giteaStatus(resp), err)
 	}
+	topics, resp, err := listAll(ctx, client, "ListRepoTopics",
+		func(api *gitea.Client, options gitea.ListOptions) ([]string, *gitea.Response, error) {
+			return api.ListRepoTopics(domain.Owner, domain.Repo,
+				gitea.ListRepoTopicsOptions{ListOptions: options})
+		})
 	if err != nil {
-		return err
+		return upstreamError(giteaStatus(resp), err)
 	}
-	topics, resp, err := client.Client.ListRepoTopics(domain.Owner, domain.Repo, gitea.ListRepoTopicsOptions{
-		ListOptions: gitea.ListOptions{
-			PageSize: 999,
-		},
-	})
+	originCtx, done := client.origin(ctx, "GetRepo")
+	repo, resp, err := client.api(originCtx).GetRepo(domain.Owner, domain.Repo)
+	done(giteaStatus(resp))
 	if err != nil {
-		return err
+		return upstreamError(giteaStatus(resp), err)
 	}
+	result.Private = repo.Private
+	result.Internal = repo.Internal
 	branchIndex := slices.IndexFunc(branches, func(x *gitea.Branch) bool { return x.Name == domain.Branch })
 	if branchIndex == -1 {
 		return errors.Wrap(ErrorNotFound, "branch not found")
@@ -120,29 +151,59 @@ func fetch(client *GiteaConfig, domain *PageDomain, result *DomainConfig) error
 		result.SHA = currentSHA
 		result.DATE = commitTime
 	}
-	//查询是否为仓库
-	result.Exists, err = client.FileExists(domain, result.BasePath+"/index.html")
+	// ############ 拉取仓库配置
+	repoConfig, err := loadRepoConfig(ctx, client, domain, result.BasePath)
 	if err != nil {
 		return err
 	}
-	if !result.Exists {
-		return nil
+	if err = loadAccess(ctx, client, domain, repoConfig, result); err != nil {
+		return err
 	}
-	result.Index = "index.html"
-	//############# 处理 404
-	if result.IsRoutePage() {
-		result.NotFound = "/index.html"
-	} else {
-		notFound, err := client.FileExists(domain, result.BasePath+"/404.html")
+	if err = loadProxy(ctx, client, domain, repoConfig, result); err != nil {
+		return err
+	}
+	result.RepoConfig = repoConfig
+	result.AutoIndex = repoConfig.AutoIndex || result.Topics[autoIndexTopic]
+	result.Markdown = repoConfig.Markdown || result.Topics[markdownTopic]
+	result.Templates = repoConfig.Templates || result.Topics[templatesTopic]
+	//查询是否为仓库
+	result.Exists = false
+	result.Index = ""
+	result.NotFound = ""
+	for _, index := range result.indexFiles(client) {
+		result.Exists, err = client.FileExists(ctx, domain, result.BasePath+"/"+index)
 		if err != nil {
 			return err
 		}
-		if notFound {
-			result.NotFound = "/404.html"
+		if result.Exists {
+			result.Index = index
+			break
+		}
+	}
+	// 开启目录索引的仓库不要求存在 index.html
+	result.Exists = result.Exists || result.AutoIndex
+	if !result.Exists {
+		return nil
+	}
+	//############# 处理 404
+	notFoundFile := "/" + result.notFoundFile(client)
+	notFound, err := client.FileExists(ctx, domain, result.BasePath+notFoundFile)
+	if err != nil {
+		return err
+	}
+	if notFound {
+		result.NotFound = notFoundFile
+	}
+	result.Fallback = ""
+	if result.RouteMode() == RouteHistory {
+		if repoConfig.Fallback != "" {
+			result.Fallback = path.Clean("/" + repoConfig.Fallback)
+		} else if result.Index != "" {
+			result.Fallback = "/" + result.Index
 		}
 	}
 	// ############ 拉取 CNAME
-	cname, err := client.ReadStringRepoFile(domain, "/CNAME")
+	cname, err := client.ReadStringRepoFile(ctx, domain, "/CNAME")
 	if err != nil && !errors.Is(err, ErrorNotFound) {
 		// ignore not fond error
 		return err
@@ -169,84 +230,132 @@ func (receiver *DomainConfig) tag(path string) string {
 		fmt.Sprintf("%s|%s|%s", receiver.SHA, receiver.PageDomain.Key(), path))))
 }
 
-func (receiver *DomainConfig) withNotFoundPage(
+// notFoundPage 从请求所在目录逐级向上查找 404 页面，例如 /docs/ 下使用 /docs/404.html
+func (receiver *DomainConfig) notFoundPage(
+	ctx context.Context,
 	client *GiteaConfig,
-	response *FakeResponse,
-) error {
-	if receiver.NotFound == "" {
-		// 没有默认页面
-		return ErrorNotFound
-	}
-	notFound, _ := receiver.FileCache.Get(receiver.NotFound)
-	if notFound == nil {
-		// 不存在 notfound
-		domain := &receiver.PageDomain
-		fileContext, err := client.OpenFileContext(domain, receiver.BasePath+receiver.NotFound)
+	filePath string,
+	request *http.Request,
+) (*FakeResponse, error) {
+	if receiver.Fallback != "" && acceptsFallback(filePath, request) {
+		response, err := receiver.openPage(ctx, client, receiver.Fallback)
+		if err == nil {
+			client.Logger.Debug("use route fallback page.", zap.String("page", receiver.Fallback))
+			return response, nil
+		} else if !errors.Is(err, ErrorNotFound) {
+			return nil, err
+		}
+	}
+	pages := make([]string, 0)
+	name := receiver.notFoundFile(client)
+	for dir := path.Dir(filePath + "_"); dir != "/" && dir != "."; dir = path.Dir(dir) {
+		pages = append(pages, dir+"/"+name)
+	}
+	if receiver.NotFound != "" {
+		pages = append(pages, receiver.NotFound)
+	}
+	for _, page := range pages {
+		response, err := receiver.openPage(ctx, client, page)
 		if errors.Is(err, ErrorNotFound) {
-			//缓存 not found 不存在
-			receiver.FileCache.Set(receiver.NotFound, make([]byte, 0), cache.DefaultExpiration)
-			return err
+			continue
 		} else if err != nil {
-			return err
-		}
-		length, _ := strconv.Atoi(fileContext.Header.Get("Content-Length"))
-		if length > client.CacheMaxSize {
-			client.Logger.Debug("default page too large.")
-			response.Body = fileContext.Body
-			response.CacheModeIgnore()
-		} else {
-			// 保存缓存
-			client.Logger.Debug("create default error page.")
-			defer fileContext.Body.Close()
-			defBuf, _ := io.ReadAll(fileContext.Body)
-			receiver.FileCache.Set(receiver.NotFound, defBuf, cache.DefaultExpiration)
-			response.Body = NewByteBuf(defBuf)
-			response.CacheModeMiss()
-		}
-		response.ContentTypeExt(receiver.NotFound)
-		response.Length(length)
-	} else {
-		notFound := notFound.([]byte)
-		if len(notFound) == 0 {
-			// 不存在 NotFound
-			return ErrorNotFound
+			return nil, err
 		}
-		response.Length(len(notFound))
-		response.Body = NewByteBuf(notFound)
-		response.CacheModeHit()
-	}
-	client.Logger.Debug("use cache error page.")
-	response.ContentTypeExt(receiver.NotFound)
-	if receiver.IsRoutePage() {
-		response.StatusCode = http.StatusOK
-	} else {
+		client.Logger.Debug("use error page.", zap.String("page", page))
 		response.StatusCode = http.StatusNotFound
+		return response, nil
 	}
-	return nil
+	// 没有默认页面
+	return nil, ErrorNotFound
 }
 
-// todo: 读写加锁
 func (receiver *DomainConfig) getCachedData(
+	ctx context.Context,
 	client *GiteaConfig,
 	path string,
+	request *http.Request,
 ) (*FakeResponse, error) {
-	result := NewFakeResponse()
-	if strings.HasSuffix(path, "/") {
-		path = path + "index.html"
+	for _, candidate := range receiver.candidates(client, path) {
+		result, err := receiver.openFile(ctx, client, candidate.path)
+		if errors.Is(err, ErrorNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if candidate.redirect != "" {
+			_ = result.Body.Close()
+			return redirectResponse(candidate.redirect), nil
+		}
+		if candidate.render {
+			return receiver.renderMarkdown(client, result, candidate.path)
+		}
+		return receiver.renderPage(ctx, client, result, candidate.path)
+	}
+	if receiver.AutoIndex {
+		result, err := receiver.autoIndex(ctx, client, path, request)
+		if err == nil || !errors.Is(err, ErrorNotFound) {
+			return result, err
+		}
+	}
+	//使用 NotFound 内容
+	return receiver.notFoundPage(ctx, client, path, request)
+}
+
+// openPage 读取页面，开启模板时渲染 HTML 文件，开启 Markdown 时渲染 .md 文件
+func (receiver *DomainConfig) openPage(
+	ctx context.Context,
+	client *GiteaConfig,
+	filePath string,
+) (*FakeResponse, error) {
+	result, err := receiver.openFile(ctx, client, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if receiver.Markdown && path.Ext(filePath) == ".md" {
+		// 回退页面与 404 页面可能是 index.md 或 README.md
+		return receiver.renderMarkdown(client, result, filePath)
 	}
+	return receiver.renderPage(ctx, client, result, filePath)
+}
+
+func (receiver *DomainConfig) renderPage(
+	ctx context.Context,
+	client *GiteaConfig,
+	result *FakeResponse,
+	filePath string,
+) (*FakeResponse, error) {
+	if !receiver.Templates || path.Ext(filePath) != ".html" {
+		return result, nil
+	}
+	return receiver.renderTemplate(ctx, client, result, filePath)
+}
+
+// newResponse 创建带有自定义响应头与 ETag 的响应
+func (receiver *DomainConfig) newResponse(client *GiteaConfig, key string) *FakeResponse {
+	result := NewFakeResponse()
 	for k, v := range client.CustomHeaders {
 		result.SetHeader(k, v)
 	}
-	result.ETag(receiver.tag(path))
+	result.ETag(receiver.tag(key))
+	return result
+}
+
+// openFile 读取文件，文件不存在时返回 ErrorNotFound
+// todo: 读写加锁
+func (receiver *DomainConfig) openFile(
+	ctx context.Context,
+	client *GiteaConfig,
+	path string,
+) (*FakeResponse, error) {
+	result := receiver.newResponse(client, path)
 	result.ContentTypeExt(path)
 	cacheBuf, _ := receiver.FileCache.Get(path)
 	// 使用缓存内容
 	if cacheBuf != nil {
 		cacheBuf := cacheBuf.([]byte)
 		if len(cacheBuf) == 0 {
-			//使用 NotFound 内容
 			client.Logger.Debug("location not found ,", zap.Any("path", path))
-			return result, receiver.withNotFoundPage(client, result)
+			return nil, ErrorNotFound
 		} else {
 			// 使用缓存
 			client.Logger.Debug("location use cache ,", zap.Any("path", path))
@@ -257,19 +366,33 @@ func (receiver *DomainConfig) getCachedData(
 			result.CacheModeHit()
 			return result, nil
 		}
+	} else if sharedBuf := client.Shared.loadFile(ctx, receiver.tag(path)); sharedBuf != nil {
+		// 使用其他节点的缓存
+		client.Logger.Debug("location use shared cache ,", zap.Any("path", path))
+		receiver.FileCache.Set(path, sharedBuf, cache.DefaultExpiration)
+		result.Body = NewByteBuf(sharedBuf)
+		result.Length(len(sharedBuf))
+		result.CacheModeShared()
+		return result, nil
 	} else {
 		// 添加缓存
 		client.Logger.Debug("location add cache ,", zap.Any("path", path))
 		domain := *(&receiver.PageDomain)
 		domain.Branch = receiver.SHA
-		fileContext, err := client.OpenFileContext(&domain, receiver.BasePath+path)
+		release, err := client.acquire(ctx, domain.Owner)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		fileContext, err := client.OpenFileContext(ctx, &domain, receiver.BasePath+path)
 		if err != nil && !errors.Is(err, ErrorNotFound) {
+			client.Metrics.fetchError("file", err)
 			return nil, err
 		} else if errors.Is(err, ErrorNotFound) {
 			client.Logger.Debug("location not found and src not found,", zap.Any("path", path))
 			// 不存在且源不存在
 			receiver.FileCache.Set(path, make([]byte, 0), cache.DefaultExpiration)
-			return result, receiver.withNotFoundPage(client, result)
+			return nil, ErrorNotFound
 		} else {
 			// 源存在，执行缓存
 			client.Logger.Debug("location found and set cache,", zap.Any("path", path))
@@ -286,6 +409,7 @@ func (receiver *DomainConfig) getCachedData(
 				// 未超过大小，缓存
 				body, _ := io.ReadAll(fileContext.Body)
 				receiver.FileCache.Set(path, body, cache.DefaultExpiration)
+				client.Shared.storeFile(ctx, receiver.tag(path), body)
 				result.Body = NewByteBuf(body)
 				result.Length(len(body))
 				result.CacheModeMiss()
@@ -299,12 +423,19 @@ func (receiver *DomainConfig) Copy(
 	client *GiteaConfig,
 	path string,
 	writer http.ResponseWriter,
-	_ *http.Request,
-) (bool, error) {
-	fakeResp, err := receiver.getCachedData(client, path)
+	request *http.Request,
+) (_ bool, err error) {
+	ctx, span := startSpan(request.Context(), "pages.copy",
+		append(domainAttributes(&receiver.PageDomain), attribute.String("pages.path", path))...)
+	defer func() { endSpan(span, err) }()
+	fakeResp, err := receiver.getCachedData(ctx, client, path, request)
 	if err != nil {
 		return false, err
 	}
+	span.SetAttributes(attribute.String("pages.cache", fakeResp.Header.Get("Pages-Server-Cache")))
+	if location := fakeResp.Header.Get("Location"); location != "" && request.URL.RawQuery != "" {
+		fakeResp.Header.Set("Location", location+"?"+request.URL.RawQuery)
+	}
 	for k, v := range fakeResp.Header {
 		for _, s := range v {
 			writer.Header().Add(k, s)
@@ -319,7 +450,9 @@ func (receiver *DomainConfig) Copy(
 }
 
 // FetchRepo 拉取 Repo 信息
-func (c *DomainCache) FetchRepo(client *GiteaConfig, domain *PageDomain) (*DomainConfig, bool, error) {
+func (c *DomainCache) FetchRepo(ctx context.Context, client *GiteaConfig, domain *PageDomain) (_ *DomainConfig, _ bool, err error) {
+	ctx, span := startSpan(ctx, "pages.fetch_repo", domainAttributes(domain)...)
+	defer func() { endSpan(span, err) }()
 	nextTime := time.Now().UnixMilli() - c.ttl.Milliseconds()
 	cacheKey := domain.Key()
 	result, _ := c.Get(cacheKey)
@@ -339,14 +472,33 @@ func (c *DomainCache) FetchRepo(client *GiteaConfig, domain *PageDomain) (*Domai
 		if result, find := c.Get(cacheKey); find {
 			return result.(*DomainConfig), true, nil
 		}
+		if client.Missing.missing(repoMissingKey(domain)) {
+			return nil, false, errors.Wrap(ErrorNotFound, "repository not found (cached)")
+		}
 		result = &DomainConfig{
 			PageDomain: *domain,
 			FileCache:  cache.New(c.ttl, c.ttl*2),
 		}
-		if err := fetch(client, domain, result.(*DomainConfig)); err != nil {
-			return nil, false, err
+		config := result.(*DomainConfig)
+		if !client.Shared.loadDomain(ctx, domain, config) || nextTime > config.FetchTime {
+			release, err := client.acquire(ctx, domain.Owner)
+			if err != nil {
+				return nil, false, err
+			}
+			err = fetch(ctx, client, domain, config)
+			release()
+			if errors.Is(err, ErrorNotFound) {
+				// 缓存不存在的仓库或分支
+				client.Missing.add(repoMissingKey(domain))
+				return nil, false, err
+			}
+			if err != nil {
+				client.Metrics.fetchError("repo", err)
+				return nil, false, err
+			}
+			client.Shared.storeDomain(ctx, domain, config)
 		}
-		err := c.Add(cacheKey, result, cache.DefaultExpiration)
+		err = c.Add(cacheKey, result, cache.DefaultExpiration)
 		if err != nil {
 			return nil, false, err
 		}
@@ -357,6 +509,37 @@ func (c *DomainCache) FetchRepo(client *GiteaConfig, domain *PageDomain) (*Domai
 
 }
 
+// FileStats 统计所有仓库的文件缓存数量与大小
+func (c *DomainCache) FileStats() (int, int) {
+	items, size := 0, 0
+	for _, item := range c.Items() {
+		config, ok := item.Object.(*DomainConfig)
+		if !ok || config.FileCache == nil {
+			continue
+		}
+		for _, file := range config.FileCache.Items() {
+			items++
+			if body, ok := file.Object.([]byte); ok {
+				size += len(body)
+			}
+		}
+	}
+	return items, size
+}
+
+// Purge 清理 owner 下的仓库缓存，repo 为空时清理 owner 下的全部仓库
+func (c *DomainCache) Purge(owner, repo string) {
+	prefix := strings.ToLower(owner) + "|"
+	if repo != "" {
+		prefix += strings.ToLower(repo) + "|"
+	}
+	for key := range c.Items() {
+		if strings.HasPrefix(strings.ToLower(key), prefix) {
+			c.Delete(key)
+		}
+	}
+}
+
 func (c *DomainCache) Lock(any *PageDomain) func() {
 	return c.LockAny(any.Key())
 }
@@ -0,0 +1,97 @@
+package pages
+
+import (
+	"cmp"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/patrickmn/go-cache"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultNegativeTTL  = time.Minute
+	defaultNegativeSize = 10000
+)
+
+// NegativeCacheConfig 不存在的 owner 与仓库的缓存配置
+type NegativeCacheConfig struct {
+	TTL  caddy.Duration `json:"ttl,omitempty"`
+	Size int            `json:"size,omitempty"` // 最大条目数
+}
+
+// NegativeCache 记录 Gitea 中不存在的 owner 与仓库，避免重复查询
+type NegativeCache struct {
+	size int
+	*cache.Cache
+}
+
+func NewNegativeCache(config *NegativeCacheConfig) *NegativeCache {
+	ttl := defaultNegativeTTL
+	size := defaultNegativeSize
+	if config != nil && config.TTL > 0 {
+		ttl = time.Duration(config.TTL)
+	}
+	if config != nil && config.Size > 0 {
+		size = config.Size
+	}
+	return &NegativeCache{
+		size:  size,
+		Cache: cache.New(ttl, ttl*2),
+	}
+}
+
+func ownerMissingKey(owner string) string {
+	return "owner/" + strings.ToLower(owner)
+}
+
+func repoMissingKey(domain *PageDomain) string {
+	return strings.ToLower("repo/"+domain.Owner+"/"+domain.Repo+"/") + domain.Branch
+}
+
+func (c *NegativeCache) missing(key string) bool {
+	_, found := c.Get(key)
+	return found
+}
+
+// add 超出容量时先清理过期条目，仍然已满则淘汰最早记录的一批条目
+func (c *NegativeCache) add(key string) {
+	if c.ItemCount() >= c.size {
+		c.DeleteExpired()
+		if c.ItemCount() >= c.size {
+			c.evict()
+		}
+	}
+	c.SetDefault(key, true)
+}
+
+// evict 所有条目的有效期相同，过期时间最早的即为最早记录的条目
+func (c *NegativeCache) evict() {
+	items := c.Items()
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return cmp.Compare(items[a].Expiration, items[b].Expiration)
+	})
+	// 每次淘汰十分之一，避免缓存已满时每次写入都排序
+	count := max(1, c.size/10, len(keys)-c.size+1)
+	for _, key := range keys[:min(count, len(keys))] {
+		c.Delete(key)
+	}
+}
+
+// Purge 清理 owner 以及其下仓库的记录，repo 为空时清理 owner 下所有仓库
+func (c *NegativeCache) Purge(owner, repo string) {
+	c.Delete(ownerMissingKey(owner))
+	prefix := strings.ToLower("repo/" + owner + "/")
+	if repo != "" {
+		prefix += strings.ToLower(repo) + "/"
+	}
+	for key := range c.Items() {
+		if strings.HasPrefix(key, prefix) {
+			c.Delete(key)
+		}
+	}
+}
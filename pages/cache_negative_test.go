@@ -0,0 +1,62 @@
+package pages
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNegativeCacheBounded(t *testing.T) {
+	for _, size := range []int{1, 10, 100} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			c := NewNegativeCache(&NegativeCacheConfig{Size: size})
+			for i := 0; i < size*3; i++ {
+				key := fmt.Sprintf("owner/user%d", i)
+				c.add(key)
+				if c.ItemCount() > size {
+					t.Fatalf("%d entries after %d adds, limit %d", c.ItemCount(), i+1, size)
+				}
+				if !c.missing(key) {
+					t.Fatalf("newest entry %s was evicted", key)
+				}
+			}
+			if c.missing("owner/user0") {
+				t.Error("oldest entry was not evicted")
+			}
+		})
+	}
+}
+
+func TestNegativeCachePurge(t *testing.T) {
+	keys := map[string]string{
+		"owner":      ownerMissingKey("Alice"),
+		"blog":       repoMissingKey(NewPageDomain("Alice", "Blog", "gh-pages")),
+		"blog-dev":   repoMissingKey(NewPageDomain("alice", "blog", "dev")),
+		"blogger":    repoMissingKey(NewPageDomain("alice", "blogger", "gh-pages")),
+		"other":      repoMissingKey(NewPageDomain("bob", "blog", "gh-pages")),
+		"other-user": ownerMissingKey("alice2"),
+	}
+	tests := []struct {
+		owner, repo string
+		remaining   []string
+	}{
+		{"ALICE", "blog", []string{"blogger", "other", "other-user"}},
+		{"alice", "", []string{"other", "other-user"}},
+		{"bob", "blog", []string{"owner", "blog", "blog-dev", "blogger", "other-user"}},
+	}
+	for _, test := range tests {
+		c := NewNegativeCache(nil)
+		for _, key := range keys {
+			c.add(key)
+		}
+		c.Purge(test.owner, test.repo)
+		want := make(map[string]bool)
+		for _, name := range test.remaining {
+			want[name] = true
+		}
+		for name, key := range keys {
+			if c.missing(key) != want[name] {
+				t.Errorf("Purge(%q, %q): %s present = %v, want %v", test.owner, test.repo, name, c.missing(key), want[name])
+			}
+		}
+	}
+}
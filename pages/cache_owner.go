@@ -2,18 +2,27 @@ package pages
 
 import (
 	"code.gitea.io/sdk/gitea"
+	"context"
 	"github.com/patrickmn/go-cache"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
 )
 
+// 仓库的查找方式
+const (
+	RepoLookupList   = "list"   // 读取 owner 的完整仓库列表 (默认)
+	RepoLookupDirect = "direct" // 按需查询单个仓库并缓存结果
+)
+
 type OwnerCache struct {
 	ttl time.Duration
 	*cache.Cache
 	mutexes sync.Map
+	direct  bool // 不读取仓库列表，仅缓存查询过的仓库
 }
 
 func NewOwnerCache(ttl time.Duration, cacheTtl time.Duration) OwnerCache {
@@ -25,50 +34,73 @@ func NewOwnerCache(ttl time.Duration, cacheTtl time.Duration) OwnerCache {
 }
 
 type OwnerConfig struct {
-	FetchTime  int64           `json:"fetch_time,omitempty"`
-	Repos      map[string]bool `json:"repos,omitempty"`
-	LowerRepos map[string]bool `json:"lower_repos,omitempty"`
+	FetchTime  int64             `json:"fetch_time,omitempty"`
+	Repos      map[string]bool   `json:"repos,omitempty"`
+	LowerRepos map[string]string `json:"lower_repos,omitempty"` // 小写名称对应的仓库名称
+
+	looked bool // 列表模式下本周期已直接查询过仓库
+	mutex  sync.RWMutex
 }
 
 func NewOwnerConfig() *OwnerConfig {
 	return &OwnerConfig{
 		Repos:      make(map[string]bool),
-		LowerRepos: make(map[string]bool),
+		LowerRepos: make(map[string]string),
 	}
 }
 
 // 直接查询 Owner 信息
-func getOwner(giteaConfig *GiteaConfig, owner string) (*OwnerConfig, error) {
+func getOwner(ctx context.Context, giteaConfig *GiteaConfig, owner string) (*OwnerConfig, error) {
 	result := NewOwnerConfig()
-	repos, resp, err := giteaConfig.Client.ListOrgRepos(owner, gitea.ListOrgReposOptions{
-		ListOptions: gitea.ListOptions{
-			PageSize: 999,
-		},
-	})
-	if err != nil && resp.StatusCode == http.StatusNotFound {
-		// 调用用户接口查询
-		repos, resp, err = giteaConfig.Client.ListUserRepos(owner, gitea.ListReposOptions{
-			ListOptions: gitea.ListOptions{
-				PageSize: 999,
-			},
+	repos, resp, err := listAll(ctx, giteaConfig, "ListOrgRepos",
+		func(api *gitea.Client, options gitea.ListOptions) ([]*gitea.Repository, *gitea.Response, error) {
+			return api.ListOrgRepos(owner, gitea.ListOrgReposOptions{ListOptions: options})
 		})
-		if err != nil && resp.StatusCode == http.StatusNotFound {
+	if err != nil && giteaStatus(resp) == http.StatusNotFound {
+		// 调用用户接口查询
+		repos, resp, err = listAll(ctx, giteaConfig, "ListUserRepos",
+			func(api *gitea.Client, options gitea.ListOptions) ([]*gitea.Repository, *gitea.Response, error) {
+				return api.ListUserRepos(owner, gitea.ListReposOptions{ListOptions: options})
+			})
+		if err != nil && giteaStatus(resp) == http.StatusNotFound {
 			return nil, errors.Wrap(ErrorNotFound, err.Error())
 		} else if err != nil {
-			return nil, err
+			return nil, upstreamError(giteaStatus(resp), err)
 		}
 	} else if err != nil {
-		return nil, err
+		return nil, upstreamError(giteaStatus(resp), err)
 	}
 	for _, repo := range repos {
-		result.Repos[repo.Name] = true
-		result.LowerRepos[strings.ToLower(repo.Name)] = true
+		result.add(repo.Name)
 	}
 	result.FetchTime = time.Now().UnixMilli()
 	return result, nil
 }
 
-func (c *OwnerCache) GetOwnerConfig(giteaConfig *GiteaConfig, owner string) (*OwnerConfig, error) {
+// checkOwner 查询 owner 是否存在，用户接口同样可以查询组织
+func checkOwner(ctx context.Context, giteaConfig *GiteaConfig, owner string) error {
+	release, err := giteaConfig.acquire(ctx, owner)
+	if err != nil {
+		return err
+	}
+	defer release()
+	originCtx, done := giteaConfig.origin(ctx, "GetUserInfo")
+	_, resp, err := giteaConfig.api(originCtx).GetUserInfo(owner)
+	done(giteaStatus(resp))
+	if status := giteaStatus(resp); status == http.StatusNotFound {
+		return errors.Wrap(ErrorNotFound, err.Error())
+	} else if err != nil {
+		return upstreamError(status, err)
+	}
+	return nil
+}
+
+func (c *OwnerCache) GetOwnerConfig(ctx context.Context, giteaConfig *GiteaConfig, owner string) (_ *OwnerConfig, err error) {
+	ctx, span := startSpan(ctx, "pages.owner_config", attribute.String("pages.owner", owner))
+	defer func() { endSpan(span, err) }()
+	if giteaConfig.Missing.missing(ownerMissingKey(owner)) {
+		return nil, errors.Wrap(ErrorNotFound, "owner not found (cached)")
+	}
 	raw, _ := c.Get(owner)
 	// 每固定时间刷新一次
 	nextTime := time.Now().UnixMilli() - c.ttl.Milliseconds()
@@ -87,10 +119,32 @@ func (c *OwnerCache) GetOwnerConfig(giteaConfig *GiteaConfig, owner string) (*Ow
 		if raw, find := c.Get(owner); find {
 			return raw.(*OwnerConfig), nil
 		}
+		if c.direct {
+			// 仅确认 owner 存在，仓库在 LookupRepo 中逐个加入
+			if err = checkOwner(ctx, giteaConfig, owner); errors.Is(err, ErrorNotFound) {
+				giteaConfig.Missing.add(ownerMissingKey(owner))
+				return nil, err
+			} else if err != nil {
+				giteaConfig.Metrics.fetchError("owner", err)
+				return nil, errors.Wrap(err, "owner config not found")
+			}
+			result = NewOwnerConfig()
+			result.FetchTime = time.Now().UnixMilli()
+			c.Set(owner, result, cache.DefaultExpiration)
+			return result, nil
+		}
 		//不存在缓存
-		var err error
-		result, err = getOwner(giteaConfig, owner)
+		release, err := giteaConfig.acquire(ctx, owner)
 		if err != nil {
+			return nil, err
+		}
+		result, err = getOwner(ctx, giteaConfig, owner)
+		release()
+		if errors.Is(err, ErrorNotFound) {
+			giteaConfig.Missing.add(ownerMissingKey(owner))
+			return nil, err
+		} else if err != nil {
+			giteaConfig.Metrics.fetchError("owner", err)
 			return nil, errors.Wrap(err, "owner config not found")
 		}
 		c.Set(owner, result, cache.DefaultExpiration)
@@ -98,6 +152,57 @@ func (c *OwnerCache) GetOwnerConfig(giteaConfig *GiteaConfig, owner string) (*Ow
 	return result, nil
 }
 
+// LookupRepo 返回仓库的实际名称，仓库不在列表中时直接查询单个仓库，新建的仓库无需等待完整刷新
+func (c *OwnerCache) LookupRepo(
+	ctx context.Context,
+	giteaConfig *GiteaConfig,
+	config *OwnerConfig,
+	owner, repo string,
+) (string, bool, error) {
+	if name, exists := config.Canonical(repo); exists {
+		return name, true, nil
+	}
+	key := repoMissingKey(&PageDomain{Owner: owner, Repo: repo})
+	if giteaConfig.Missing.missing(key) {
+		return "", false, nil
+	}
+	if !c.direct && !config.lookup() {
+		// 列表模式以仓库列表为准，直到下次刷新或 webhook 清除
+		return "", false, nil
+	}
+	release, err := giteaConfig.acquire(ctx, owner)
+	if err != nil {
+		return "", false, err
+	}
+	defer release()
+	originCtx, done := giteaConfig.origin(ctx, "GetRepo")
+	result, resp, err := giteaConfig.api(originCtx).GetRepo(owner, repo)
+	done(giteaStatus(resp))
+	if status := giteaStatus(resp); status == http.StatusNotFound || status == http.StatusForbidden {
+		giteaConfig.Missing.add(key)
+		return "", false, nil
+	} else if err != nil {
+		giteaConfig.Metrics.fetchError("owner", err)
+		return "", false, upstreamError(status, err)
+	}
+	if !strings.EqualFold(result.Owner.UserName, owner) {
+		// 仓库已转移，按不存在处理
+		giteaConfig.Missing.add(key)
+		return "", false, nil
+	}
+	config.add(result.Name)
+	return result.Name, true, nil
+}
+
+// Purge 移除 owner 的缓存，下次访问时重新拉取仓库列表
+func (c *OwnerCache) Purge(owner string) {
+	for key := range c.Items() {
+		if strings.EqualFold(key, owner) {
+			c.Delete(key)
+		}
+	}
+}
+
 func (c *OwnerCache) Lock(any string) func() {
 	value, _ := c.mutexes.LoadOrStore(any, &sync.Mutex{})
 	mtx := value.(*sync.Mutex)
@@ -107,5 +212,32 @@ func (c *OwnerCache) Lock(any string) func() {
 }
 
 func (c *OwnerConfig) Exists(repo string) bool {
-	return c.LowerRepos[strings.ToLower(repo)]
+	_, exists := c.Canonical(repo)
+	return exists
+}
+
+// Canonical 返回 Gitea 中仓库名称的实际大小写
+func (c *OwnerConfig) Canonical(repo string) (string, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	name, exists := c.LowerRepos[strings.ToLower(repo)]
+	return name, exists
+}
+
+// lookup 每个刷新周期仅允许一次直接查询，用于发现列表刷新前新建的仓库
+func (c *OwnerConfig) lookup() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.looked {
+		return false
+	}
+	c.looked = true
+	return true
+}
+
+func (c *OwnerConfig) add(repo string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.Repos[repo] = true
+	c.LowerRepos[strings.ToLower(repo)] = repo
 }
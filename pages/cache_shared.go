@@ -0,0 +1,280 @@
+package pages
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/caddyserver/certmagic"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io/fs"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sharedPollInterval 拉取其他节点失效消息的间隔，应远小于本地缓存时间
+	sharedPollInterval = 5 * time.Second
+	// sharedMinRetention 失效消息至少保留的拉取次数，避免其他节点在读取前被删除
+	sharedMinRetention = 12
+)
+
+// SharedStorage 多节点共享的存储后端，与 Caddy 的 storage 模块 (certmagic.Storage) 兼容
+type SharedStorage interface {
+	Store(ctx context.Context, key string, value []byte) error
+	Load(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, path string, recursive bool) ([]string, error)
+	Stat(ctx context.Context, key string) (certmagic.KeyInfo, error)
+}
+
+// SharedCache 在多个实例之间共享 DomainConfig 元数据和小文件内容
+type SharedCache struct {
+	storage   SharedStorage
+	prefix    string
+	node      string
+	interval  time.Duration
+	retention time.Duration // 文件与失效消息的保留时间，超过本地缓存时间后不再有意义
+	logger    *zap.Logger
+
+	seen    map[string]bool // 已处理的失效消息
+	cleaned time.Time       // 上次清理过期文件的时间
+	mutex   sync.Mutex
+	closed  chan struct{}
+}
+
+// purgeRecord 失效消息，由接收到 webhook 的节点写入
+type purgeRecord struct {
+	Node  string `json:"node"`
+	Owner string `json:"owner"`
+	Repo  string `json:"repo,omitempty"`
+	Time  int64  `json:"time"`
+}
+
+func NewSharedCache(storage SharedStorage, prefix string, interval, retention time.Duration, logger *zap.Logger) *SharedCache {
+	node := make([]byte, 8)
+	_, _ = rand.Read(node)
+	if prefix == "" {
+		prefix = "gitea_pages"
+	}
+	return &SharedCache{
+		storage:   storage,
+		prefix:    prefix,
+		node:      hex.EncodeToString(node),
+		interval:  interval,
+		retention: max(retention, interval*sharedMinRetention),
+		logger:    logger,
+		cleaned:   time.Now(),
+		closed:    make(chan struct{}),
+	}
+}
+
+// repoPath owner 与仓库名称不区分大小写，Host 中的 owner 与 webhook 中的名称大小写可能不同
+func (s *SharedCache) repoPath(owner, repo string) string {
+	return path.Join(s.prefix, "domains", strings.ToLower(owner), strings.ToLower(repo))
+}
+
+func (s *SharedCache) domainKey(domain *PageDomain) string {
+	return path.Join(s.repoPath(domain.Owner, domain.Repo), url.PathEscape(domain.Branch)+".json")
+}
+
+func (s *SharedCache) fileKey(tag string) string {
+	return path.Join(s.prefix, "files", tag)
+}
+
+// purgeKey 每条失效消息使用新的名称，名称以写入时间开头，同步时只读取新出现的消息
+func (s *SharedCache) purgeKey(owner, repo string, now time.Time) string {
+	hash := sha1.Sum([]byte(strings.ToLower(owner) + "|" + strings.ToLower(repo)))
+	return path.Join(s.prefix, "purge", fmt.Sprintf("%d-%s-%x", now.UnixMilli(), s.node, hash))
+}
+
+// purgeTime 从失效消息的名称中读取写入时间
+func purgeTime(key string) (time.Time, bool) {
+	value, _, found := strings.Cut(path.Base(key), "-")
+	if !found {
+		return time.Time{}, false
+	}
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(millis), true
+}
+
+// loadDomain 读取共享的元数据，文件缓存等本地字段保持不变
+func (s *SharedCache) loadDomain(ctx context.Context, domain *PageDomain, result *DomainConfig) bool {
+	if s == nil {
+		return false
+	}
+	data, err := s.storage.Load(ctx, s.domainKey(domain))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			s.logger.Warn("failed to load shared domain config", zap.String("domain", domain.Key()), zap.Error(err))
+		}
+		return false
+	}
+	if err = json.Unmarshal(data, result); err != nil {
+		s.logger.Warn("invalid shared domain config", zap.String("domain", domain.Key()), zap.Error(err))
+		return false
+	}
+	return true
+}
+
+func (s *SharedCache) storeDomain(ctx context.Context, domain *PageDomain, config *DomainConfig) {
+	if s == nil {
+		return
+	}
+	data, err := json.Marshal(config)
+	if err == nil {
+		err = s.storage.Store(ctx, s.domainKey(domain), data)
+	}
+	if err != nil {
+		s.logger.Warn("failed to store shared domain config", zap.String("domain", domain.Key()), zap.Error(err))
+	}
+}
+
+// loadFile 文件内容以 SHA 区分，不需要失效处理
+func (s *SharedCache) loadFile(ctx context.Context, tag string) []byte {
+	if s == nil {
+		return nil
+	}
+	data, err := s.storage.Load(ctx, s.fileKey(tag))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func (s *SharedCache) storeFile(ctx context.Context, tag string, body []byte) {
+	if s == nil || len(body) == 0 {
+		return
+	}
+	if err := s.storage.Store(ctx, s.fileKey(tag), body); err != nil {
+		s.logger.Warn("failed to store shared file", zap.String("tag", tag), zap.Error(err))
+	}
+}
+
+// purge 清理共享的元数据并通知其他节点
+func (s *SharedCache) purge(owner, repo string) error {
+	if s == nil {
+		return nil
+	}
+	ctx := context.Background()
+	keys, err := s.storage.List(ctx, s.repoPath(owner, repo), true)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for _, key := range keys {
+		if err = s.storage.Delete(ctx, key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	now := time.Now()
+	data, err := json.Marshal(&purgeRecord{
+		Node:  s.node,
+		Owner: owner,
+		Repo:  repo,
+		Time:  now.UnixMilli(),
+	})
+	if err != nil {
+		return err
+	}
+	return s.storage.Store(ctx, s.purgeKey(owner, repo, now), data)
+}
+
+// watch 定时拉取其他节点写入的失效消息，启动前已存在的消息不再处理
+func (s *SharedCache) watch(handler func(owner, repo string)) {
+	if s == nil {
+		return
+	}
+	s.sync(nil)
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.closed:
+				return
+			case <-ticker.C:
+				s.sync(handler)
+				s.cleanup()
+			}
+		}
+	}()
+}
+
+// sync 按名称记录已处理的消息，不比较各节点的时钟，handler 为 nil 时仅记录
+func (s *SharedCache) sync(handler func(owner, repo string)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ctx := context.Background()
+	keys, err := s.storage.List(ctx, path.Join(s.prefix, "purge"), false)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			s.logger.Warn("failed to list purge records", zap.Error(err))
+		}
+		return
+	}
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if created, ok := purgeTime(key); !ok || time.Since(created) > s.retention {
+			// 过期或无法识别的消息，任意节点均可删除
+			_ = s.storage.Delete(ctx, key)
+			continue
+		}
+		seen[key] = true
+		if handler == nil || s.seen[key] {
+			continue
+		}
+		data, err := s.storage.Load(ctx, key)
+		if err != nil {
+			continue
+		}
+		var record purgeRecord
+		if err = json.Unmarshal(data, &record); err != nil || record.Node == s.node {
+			continue
+		}
+		s.logger.Info("purge from peer", zap.String("owner", record.Owner), zap.String("repo", record.Repo))
+		handler(record.Owner, record.Repo)
+	}
+	s.seen = seen
+}
+
+// cleanup 定期删除超过保留时间的共享文件
+func (s *SharedCache) cleanup() {
+	if time.Since(s.cleaned) < s.retention/4 {
+		return
+	}
+	s.cleaned = time.Now()
+	ctx := context.Background()
+	keys, err := s.storage.List(ctx, path.Join(s.prefix, "files"), true)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			s.logger.Warn("failed to list shared files", zap.Error(err))
+		}
+		return
+	}
+	for _, key := range keys {
+		info, err := s.storage.Stat(ctx, key)
+		if err != nil || !info.IsTerminal || time.Since(info.Modified) <= s.retention {
+			continue
+		}
+		if err = s.storage.Delete(ctx, key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			s.logger.Warn("failed to delete shared file", zap.String("key", key), zap.Error(err))
+		}
+	}
+}
+
+func (s *SharedCache) Close() error {
+	if s != nil {
+		close(s.closed)
+	}
+	return nil
+}
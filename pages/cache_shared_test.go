@@ -0,0 +1,72 @@
+package pages
+
+import (
+	"context"
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+	"path"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSharedCachePurge(t *testing.T) {
+	storage := &certmagic.FileStorage{Path: t.TempDir()}
+	ctx := context.Background()
+	local := NewSharedCache(storage, "", time.Second, time.Hour, zap.NewNop())
+	peer := NewSharedCache(storage, "", time.Second, time.Hour, zap.NewNop())
+	domain := NewPageDomain("Alice", "Blog", "gh-pages")
+	local.storeDomain(ctx, domain, &DomainConfig{Exists: true})
+	if !peer.loadDomain(ctx, NewPageDomain("alice", "blog", "gh-pages"), &DomainConfig{}) {
+		t.Fatal("shared domain config must ignore owner and repository case")
+	}
+
+	var purged []string
+	handler := func(owner, repo string) { purged = append(purged, owner+"/"+repo) }
+	peer.sync(nil)
+	if err := local.purge("alice", "BLOG"); err != nil {
+		t.Fatal(err)
+	}
+	if peer.loadDomain(ctx, domain, &DomainConfig{}) {
+		t.Error("purge must delete the shared domain config")
+	}
+	peer.sync(handler)
+	if !slices.Equal(purged, []string{"alice/BLOG"}) {
+		t.Errorf("peer purged %v, want [alice/BLOG]", purged)
+	}
+	peer.sync(handler)
+	if len(purged) != 1 {
+		t.Errorf("purge record handled %d times, want once", len(purged))
+	}
+	local.sync(handler)
+	if len(purged) != 1 {
+		t.Error("a node must not handle its own purge records")
+	}
+}
+
+func TestSharedCacheExpiredRecords(t *testing.T) {
+	storage := &certmagic.FileStorage{Path: t.TempDir()}
+	ctx := context.Background()
+	cache := NewSharedCache(storage, "", time.Second, time.Minute, zap.NewNop())
+	old := cache.purgeKey("alice", "blog", time.Now().Add(-time.Hour))
+	fresh := cache.purgeKey("bob", "site", time.Now())
+	invalid := path.Join(cache.prefix, "purge", "invalid")
+	for _, key := range []string{old, fresh, invalid} {
+		if err := storage.Store(ctx, key, []byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache.sync(nil)
+	for key, want := range map[string]bool{old: false, fresh: true, invalid: false} {
+		if got := storage.Exists(ctx, key); got != want {
+			t.Errorf("record %s exists = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestSharedCacheRetention(t *testing.T) {
+	cache := NewSharedCache(&certmagic.FileStorage{Path: t.TempDir()}, "", sharedPollInterval, sharedPollInterval, zap.NewNop())
+	if cache.retention < sharedPollInterval*sharedMinRetention {
+		t.Errorf("retention %v must cover %d poll intervals", cache.retention, sharedMinRetention)
+	}
+}
@@ -1,88 +1,129 @@
 package pages
 
 import (
-	"code.gitea.io/sdk/gitea"
 	"go.uber.org/zap"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type AutoRedirect struct {
-	Enabled bool
-	Scheme  string
-	Code    int
+	Enabled bool   `json:"enabled"`
+	Scheme  string `json:"scheme"`
+	Code    int    `json:"code"`
 }
 
 type PageClient struct {
-	BaseDomain   string
-	GiteaConfig  *GiteaConfig
+	Sites        []*PageSite // 第一个为主站点
 	DomainAlias  *CustomDomains
 	ErrorPages   *ErrorPages
-	AutoRedirect *AutoRedirect
-	OwnerCache   *OwnerCache
-	DomainCache  *DomainCache
+	Webhook      *Webhook
+	Health       *Health
+	Limiter      *Limiter
+	Canonical    bool // 仓库名称大小写不一致时 301 跳转到规范地址
+	metrics      *Metrics
 	logger       *zap.Logger
+	accessLogger *zap.Logger
 }
 
 func (p *PageClient) Close() error {
-	if p.OwnerCache != nil {
-		p.OwnerCache.Cache.Flush()
+	for _, site := range p.Sites {
+		_ = site.Close()
 	}
-	if p.DomainCache != nil {
-		_ = p.DomainCache.Close()
+	return nil
+}
+
+// Purge 清理所有站点中的仓库缓存，启用共享缓存时同时通知其他节点
+func (p *PageClient) Purge(owner, repo string) error {
+	for _, site := range p.Sites {
+		site.purgeLocal(owner, repo)
+		if err := site.GiteaConfig.Shared.purge(owner, repo); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 func NewPageClient(
 	config *MiddlewareConfig,
+	storage SharedStorage,
 	logger *zap.Logger,
 ) (*PageClient, error) {
-	options := make([]gitea.ClientOption, 0)
-	if config.Token != "" {
-		options = append(options, gitea.SetToken(config.Token))
-	}
-	options = append(options, gitea.SetGiteaVersion(""))
-	client, err := gitea.NewClient(config.Server, options...)
+	alias, err := NewCustomDomains(config.Alias, config.SharedAlias, logger)
 	if err != nil {
 		return nil, err
 	}
-	alias, err := NewCustomDomains(config.Alias, config.SharedAlias)
+	pages, err := NewErrorPages(config.ErrorPages)
 	if err != nil {
 		return nil, err
 	}
-	pages, err := NewErrorPages(config.ErrorPages)
+	indexTemplate, err := NewIndexTemplate(config.AutoIndex)
 	if err != nil {
 		return nil, err
 	}
-	ownerCache := NewOwnerCache(config.CacheRefresh, config.CacheTimeout)
-	giteaConfig := &GiteaConfig{
-		Server:        config.Server,
-		Token:         config.Token,
-		Client:        client,
-		Logger:        logger,
-		CacheMaxSize:  config.CacheMaxSize,
-		CustomHeaders: config.CustomHeaders,
+	markdownTemplate, err := NewMarkdownTemplate(config.Markdown)
+	if err != nil {
+		return nil, err
 	}
-	domainCache := NewDomainCache(config.CacheRefresh, config.CacheTimeout)
-	logger.Info("gitea cache ttl " + strconv.FormatInt(config.CacheTimeout.Milliseconds(), 10) + " ms .")
-	return &PageClient{
-		GiteaConfig:  giteaConfig,
-		BaseDomain:   "." + strings.Trim(config.Domain, "."),
+	logger.Info("gitea cache ttl " + strconv.FormatInt(time.Duration(config.CacheTimeout).Milliseconds(), 10) + " ms .")
+	logger.Debug("gitea pages config", zap.Any("config", config.redacted()))
+	result := &PageClient{
 		DomainAlias:  alias,
 		ErrorPages:   pages,
 		logger:       logger,
-		AutoRedirect: config.AutoRedirect,
-		DomainCache:  &domainCache,
-		OwnerCache:   &ownerCache,
-	}, nil
+		accessLogger: logger.Named("access"),
+		Webhook:      config.Webhook,
+		Limiter:      NewLimiter(config.RateLimit),
+		Canonical:    config.Canonical,
+	}
+	primary, err := NewPageSite("", config.primarySite(), config, storage, logger)
+	if err != nil {
+		return nil, err
+	}
+	result.Sites = append(result.Sites, primary)
+	for _, siteConfig := range config.Sites {
+		siteConfig = config.inheritSite(siteConfig)
+		site, err := NewPageSite(strings.Trim(siteConfig.Domain, "."), siteConfig, config, storage,
+			logger.With(zap.String("site", siteConfig.Domain)))
+		if err != nil {
+			return nil, err
+		}
+		result.Sites = append(result.Sites, site)
+	}
+	if config.HealthPath != "" {
+		result.Health = &Health{
+			Path:  strings.TrimSuffix(config.HealthPath, "/"),
+			Allow: config.HealthAllow,
+		}
+		for _, host := range config.HealthHosts {
+			result.Health.Hosts = append(result.Health.Hosts, strings.ToLower(host))
+		}
+	}
+	pages.debug = config.DebugErrors
+	if config.ErrorRepo != nil {
+		repo := *config.ErrorRepo
+		if repo.Branch == "" {
+			repo.Branch = primary.DefaultBranch
+		}
+		pages.repo = &repo
+		pages.site = primary
+	}
+	for _, site := range result.Sites {
+		site.GiteaConfig.Limiter = result.Limiter
+		site.GiteaConfig.IndexTemplate = indexTemplate
+		site.GiteaConfig.MarkdownTemplate = markdownTemplate
+		site.GiteaConfig.Shared.watch(site.purgeLocal)
+	}
+	return result, nil
 }
 
 func (p *PageClient) Validate() error {
-	ver, _, err := p.GiteaConfig.Client.ServerVersion()
-	p.logger.Info("Gitea Version ", zap.String("version", ver))
-	if err != nil {
-		p.logger.Warn("Failed to get Gitea version", zap.Error(err))
+	for _, site := range p.Sites {
+		ver, _, err := site.GiteaConfig.Client.ServerVersion()
+		p.logger.Info("Gitea Version ", zap.String("server", site.GiteaConfig.Server), zap.String("version", ver))
+		if err != nil {
+			p.logger.Warn("Failed to get Gitea version", zap.String("server", site.GiteaConfig.Server), zap.Error(err))
+		}
 	}
 	return nil
 }
@@ -2,8 +2,8 @@ package pages
 
 import (
 	"encoding/json"
-	"fmt"
 	cmap "github.com/orcaman/concurrent-map/v2"
+	"go.uber.org/zap"
 	"os"
 	"strings"
 	"sync"
@@ -22,6 +22,8 @@ type CustomDomains struct {
 	Local string `json:"-"`
 	// 是否全局共享
 	Share bool `json:"-"`
+
+	logger *zap.Logger
 }
 
 func (d *CustomDomains) Get(host string) (PageDomain, bool) {
@@ -37,6 +39,9 @@ func (d *CustomDomains) add(domain *PageDomain, aliases ...string) {
 	defer d.Mutex.Unlock()
 	for _, alias := range aliases {
 		key := strings.ToLower(domain.Key())
+		if domain.Site != "" {
+			key = strings.ToLower(domain.Site) + "|" + key
+		}
 		alias = strings.ToLower(alias)
 		old, b := d.Reverse.Get(key)
 		if b {
@@ -57,14 +62,14 @@ func (d *CustomDomains) add(domain *PageDomain, aliases ...string) {
 		marshal, err := json.Marshal(d)
 		err = os.WriteFile(d.Local, marshal, 0644)
 		if err != nil {
-			fmt.Printf("%v\n", err)
+			d.logger.Error("failed to save alias file", zap.String("path", d.Local), zap.Error(err))
 		}
 	}
 }
 
-func NewCustomDomains(local string, share bool) (*CustomDomains, error) {
+func NewCustomDomains(local string, share bool, logger *zap.Logger) (*CustomDomains, error) {
 	if share {
-		fmt.Printf("Global Alias Enabled.\n")
+		logger.Info("global alias enabled.")
 	}
 	stat, err := os.Stat(local)
 	alias := cmap.New[PageDomain]()
@@ -75,15 +80,16 @@ func NewCustomDomains(local string, share bool) (*CustomDomains, error) {
 		Mutex:   sync.Mutex{},
 		Local:   local,
 		Share:   share,
+		logger:  logger,
 	}
-	fmt.Printf("Discover alias file :%s.\n", local)
+	logger.Info("discover alias file.", zap.String("path", local))
 	if local != "" && err == nil && !stat.IsDir() {
 		bytes, err := os.ReadFile(local)
 		if err != nil {
 			return nil, err
 		}
 		err = json.Unmarshal(bytes, result)
-		fmt.Printf("Found %d Alias records.\n", result.Alias.Count())
+		logger.Info("found alias records.", zap.Int("count", result.Alias.Count()))
 
 		if err != nil {
 			return nil, err
@@ -1,10 +1,109 @@
 package pages
 
-import "github.com/pkg/errors"
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"strings"
+)
 
 var (
 	// ErrorNotMatches 确认这不是 Gitea Pages 相关的域名
 	ErrorNotMatches = errors.New("not matching")
 	ErrorNotFound   = errors.New("not found")
-	ErrorInternal   = errors.New("internal error")
+	// ErrorUnauthorized 需要访客提供凭据
+	ErrorUnauthorized = errors.New("unauthorized")
+	// ErrorForbidden 访客无权访问
+	ErrorForbidden = errors.New("forbidden")
+	// ErrorTooManyRequests 超出请求频率限制
+	ErrorTooManyRequests = errors.New("too many requests")
+	ErrorInternal        = errors.New("internal error")
+	// ErrorGone 资源已被永久移除
+	ErrorGone = errors.New("gone")
+	// ErrorBadGateway 转发到上游失败或 Gitea 返回了异常的响应
+	ErrorBadGateway = errors.New("bad gateway")
+	// ErrorUnavailable Gitea 暂时不可用
+	ErrorUnavailable = errors.New("service unavailable")
+	// ErrorGatewayTimeout 等待 Gitea 或上游超时
+	ErrorGatewayTimeout = errors.New("gateway timeout")
 )
+
+// errorStatus 错误对应的响应状态码，网络错误按超时与否区分
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrorNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrorUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrorForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrorGone):
+		return http.StatusGone
+	case errors.Is(err, ErrorTooManyRequests):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrorBadGateway):
+		return http.StatusBadGateway
+	case errors.Is(err, ErrorUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrorGatewayTimeout), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return http.StatusGatewayTimeout
+		}
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// publicMessages 可以对外展示的错误说明，详细错误仅记录在日志中
+var publicMessages = map[int]string{
+	http.StatusNotFound:            "page not found",
+	http.StatusUnauthorized:        "authentication required",
+	http.StatusForbidden:           "access denied",
+	http.StatusGone:                "page no longer available",
+	http.StatusTooManyRequests:     "too many requests, please retry later",
+	http.StatusBadGateway:          "upstream returned an invalid response",
+	http.StatusServiceUnavailable:  "service temporarily unavailable",
+	http.StatusGatewayTimeout:      "upstream timed out",
+	http.StatusInternalServerError: "internal server error",
+}
+
+func publicMessage(code int) string {
+	if message, ok := publicMessages[code]; ok {
+		return message
+	}
+	return strings.ToLower(http.StatusText(code))
+}
+
+// upstreamError 按 Gitea 的响应状态码标记错误
+func upstreamError(status int, err error) error {
+	switch {
+	case status == http.StatusGone:
+		return errors.Wrap(ErrorGone, err.Error())
+	case status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable:
+		return errors.Wrap(ErrorUnavailable, err.Error())
+	case status == http.StatusGatewayTimeout:
+		return errors.Wrap(ErrorGatewayTimeout, err.Error())
+	case status >= http.StatusBadRequest:
+		return errors.Wrap(ErrorBadGateway, err.Error())
+	}
+	return err
+}
+
+// StackField 输出 pkg/errors 记录的调用栈
+func StackField(err error) zap.Field {
+	type stackTracer interface {
+		StackTrace() errors.StackTrace
+	}
+	var tracer stackTracer
+	if errors.As(err, &tracer) {
+		return zap.String("stacktrace", fmt.Sprintf("%+v", tracer.StackTrace()))
+	}
+	return zap.Skip()
+}
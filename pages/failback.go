@@ -3,18 +3,56 @@ package pages
 import (
 	"embed"
 	"github.com/Masterminds/sprig/v3"
+	"github.com/caddyserver/caddy/v2"
 	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"text/template"
 )
 
 type ErrorMetadata struct {
-	StatusCode int
-	Request    *http.Request
-	Error      string
+	StatusCode  int
+	StatusText  string // 状态码的标准说明，例如 Not Found
+	Request     *http.Request
+	Error       string      // 对外展示的错误说明
+	Detail      string      // 详细错误，仅在开启 debug_errors 时填写
+	Domain      *PageDomain // 解析到的仓库，未解析时为 nil
+	Owner       string      // 请求的 owner 与仓库，不存在时同样记录
+	Repo        string
+	OwnerExists bool
+	RepoExists  bool
+	Server      string // Gitea 服务地址，仅在开启 debug_errors 时填写
+	RequestID   string
+	Suggestions []*Suggestion // owner 下名称相近的 Pages 仓库
 }
 
+// 错误页面的格式，json 与 text 模板可通过 errors 中的 json、text 或 404.json 等配置
+const (
+	errorFormatHTML = "html"
+	errorFormatJSON = "json"
+	errorFormatText = "text"
+)
+
+var errorContentTypes = map[string]string{
+	errorFormatHTML: "text/html;charset=utf-8",
+	errorFormatJSON: "application/json",
+	errorFormatText: "text/plain;charset=utf-8",
+}
+
+// 默认的 json 与 text 模板
+const (
+	defaultJSONError = `{"status":{{ .StatusCode }},"error":{{ .Error | toJson }}` +
+		`{{ if .Detail }},"detail":{{ .Detail | toJson }}{{ end }}}` + "\n"
+	defaultTextError = `{{ .StatusCode }} {{ .Error }}{{ if .Detail }}: {{ .Detail }}{{ end }}` + "\n"
+)
+
+// errorRepoPages 错误页面仓库中可用的模板文件
+var errorRepoPages = []string{"400", "401", "403", "404", "410", "429", "500", "502", "503", "504", "40x", "50x"}
+
 var (
 	//go:embed 40x.gohtml 50x.gohtml
 	embedPages embed.FS
@@ -22,11 +60,22 @@ var (
 
 type ErrorPages struct {
 	errorPages map[string]*template.Template
+	debug      bool // 在错误页面中展示详细错误
+
+	repo      *PageDomain // 存放错误页面模板的仓库，按站点缓存刷新
+	site      *PageSite
+	mutex     sync.Mutex
+	repoSHA   string
+	repoPages map[string]*template.Template
 }
 
 func newTemplate(key, text string) (*template.Template, error) {
 	return template.New(key).Funcs(sprig.TxtFuncMap()).Parse(text)
 }
+
+func newRepoTemplate(key, text string) (*template.Template, error) {
+	return template.New(key).Funcs(repoFuncMap(nil)).Parse(text)
+}
 func NewErrorPages(pagesTmpl map[string]string) (*ErrorPages, error) {
 	pages := make(map[string]*template.Template)
 	for key, value := range pagesTmpl {
@@ -37,6 +86,15 @@ func NewErrorPages(pagesTmpl map[string]string) (*ErrorPages, error) {
 		pages[key] = tmpl
 	}
 
+	for key, text := range map[string]string{errorFormatJSON: defaultJSONError, errorFormatText: defaultTextError} {
+		if pages[key] == nil {
+			tmpl, err := newTemplate(key, text)
+			if err != nil {
+				return nil, err
+			}
+			pages[key] = tmpl
+		}
+	}
 	if pages["40x"] == nil {
 		data, err := embedPages.ReadFile("40x.gohtml")
 		if err != nil {
@@ -63,32 +121,164 @@ func NewErrorPages(pagesTmpl map[string]string) (*ErrorPages, error) {
 }
 
 func (p *ErrorPages) flushError(err error, request *http.Request, writer http.ResponseWriter) error {
-	var code = http.StatusInternalServerError
 	if errors.Is(err, ErrorNotMatches) {
 		// 跳过不匹配
 		return err
-	} else if errors.Is(err, ErrorNotFound) {
-		code = http.StatusNotFound
-	} else {
-		code = http.StatusInternalServerError
 	}
-	var metadata = &ErrorMetadata{
-		StatusCode: code,
-		Request:    request,
-		Error:      err.Error(),
+	code := errorStatus(err)
+	if code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable {
+		if writer.Header().Get("Retry-After") == "" {
+			writer.Header().Set("Retry-After", "1")
+		}
+	}
+	metadata := newErrorMetadata(request, code)
+	if p.debug {
+		metadata.Detail = err.Error()
+		if site := getRequestState(request.Context()).Site; site != nil {
+			metadata.Server = site.GiteaConfig.Server
+		}
 	}
 	codeStr := strconv.Itoa(code)
-	writer.Header().Add("Content-Type", "text/html;charset=utf-8")
+	class := "50x"
+	if code >= 400 && code < 500 {
+		class = "40x"
+	}
+	format := negotiateError(request.Header.Get("Accept"))
+	writer.Header().Add("Vary", "Accept")
+	writer.Header().Set("Content-Type", errorContentTypes[format])
 	writer.WriteHeader(code)
-	if result := p.errorPages[codeStr]; result != nil {
+	if format != errorFormatHTML {
+		// 例如 404.json，未配置时使用 json
+		if result := p.errorPages[codeStr+"."+format]; result != nil {
+			return result.Execute(writer, metadata)
+		}
+		return p.errorPages[format].Execute(writer, metadata)
+	}
+	repoPages := p.loadRepoPages(request)
+	for _, pages := range []map[string]*template.Template{repoPages, p.errorPages} {
+		if result := pages[codeStr]; result != nil {
+			return result.Execute(writer, metadata)
+		}
+	}
+	if result := repoPages[class]; result != nil {
 		return result.Execute(writer, metadata)
 	}
-	switch {
-	case code >= 400 && code < 500:
-		return p.errorPages["40x"].Execute(writer, metadata)
-	case code >= 500:
-		return p.errorPages["50x"].Execute(writer, metadata)
-	default:
-		return p.errorPages["50x"].Execute(writer, metadata)
+	return p.errorPages[class].Execute(writer, metadata)
+}
+
+// negotiateError 按 Accept 选择错误页面格式，权重相同时具体类型优先于通配，其次按出现顺序
+func negotiateError(accept string) string {
+	result := errorFormatHTML
+	bestQ := -1.0
+	bestExact := false
+	for _, item := range strings.Split(accept, ",") {
+		media, params, _ := strings.Cut(item, ";")
+		media = strings.ToLower(strings.TrimSpace(media))
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if key, value, found := strings.Cut(strings.TrimSpace(param), "="); found && key == "q" {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		var format string
+		exact := true
+		switch {
+		case media == "text/html" || media == "application/xhtml+xml":
+			format = errorFormatHTML
+		case media == "application/json" || strings.HasSuffix(media, "+json"):
+			format = errorFormatJSON
+		case media == "text/plain":
+			format = errorFormatText
+		case media == "*/*" || media == "text/*":
+			format, exact = errorFormatHTML, false
+		default:
+			continue
+		}
+		if q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && exact && !bestExact) {
+			result, bestQ, bestExact = format, q, exact
+		}
+	}
+	return result
+}
+
+func newErrorMetadata(request *http.Request, code int) *ErrorMetadata {
+	state := getRequestState(request.Context())
+	result := &ErrorMetadata{
+		StatusCode:  code,
+		StatusText:  http.StatusText(code),
+		Request:     request,
+		Error:       publicMessage(code),
+		Domain:      state.Domain,
+		Owner:       state.Owner,
+		Repo:        state.Repo,
+		OwnerExists: state.OwnerExists,
+		RepoExists:  state.RepoExists,
+		RequestID:   requestID(request),
+	}
+	if state.Site != nil && code == http.StatusNotFound && state.OwnerExists {
+		result.Suggestions = state.Site.suggest(state.Owner, state.Repo)
+	}
+	return result
+}
+
+// requestID 使用 Caddy 为每个请求生成的 UUID，不信任请求头中的值
+func requestID(request *http.Request) string {
+	if repl, ok := request.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+		return repl.ReplaceAll("{http.request.uuid}", "")
+	}
+	return ""
+}
+
+// loadRepoPages 读取错误页面仓库中的模板，提交变化时重新解析，读取失败时使用内置模板
+func (p *ErrorPages) loadRepoPages(request *http.Request) map[string]*template.Template {
+	if p.repo == nil || p.site == nil {
+		return nil
+	}
+	ctx := request.Context()
+	client := p.site.GiteaConfig
+	config, _, err := p.site.DomainCache.FetchRepo(ctx, client, p.repo)
+	p.mutex.Lock()
+	current, currentSHA := p.repoPages, p.repoSHA
+	p.mutex.Unlock()
+	if err != nil {
+		client.Logger.Warn("failed to load error pages repository.",
+			zap.String("repo", p.repo.Key()), zap.Error(err))
+		// 沿用上次读取的模板
+		return current
+	}
+	if currentSHA == config.SHA {
+		return current
+	}
+	// 回源时不持有锁，并发请求可能重复读取，结果相同
+	pages := make(map[string]*template.Template)
+	for _, name := range errorRepoPages {
+		response, err := config.openFile(ctx, client, "/"+name+".html")
+		if errors.Is(err, ErrorNotFound) {
+			continue
+		} else if err != nil {
+			client.Logger.Warn("failed to load error page.", zap.String("page", name), zap.Error(err))
+			return current
+		}
+		data, err := io.ReadAll(response.Body)
+		_ = response.Body.Close()
+		if err != nil {
+			return current
+		}
+		tmpl, err := newRepoTemplate(name, string(data))
+		if err != nil {
+			client.Logger.Warn("invalid error page template, ignored.", zap.String("page", name), zap.Error(err))
+			continue
+		}
+		pages[name] = tmpl
 	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.repoSHA = config.SHA
+	p.repoPages = pages
+	return pages
 }
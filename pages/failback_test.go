@@ -0,0 +1,71 @@
+package pages
+
+import (
+	"github.com/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateError(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", errorFormatHTML},
+		{"*/*", errorFormatHTML},
+		{"text/*", errorFormatHTML},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8", errorFormatHTML},
+		{"application/json, text/plain, */*", errorFormatJSON},
+		{"application/json", errorFormatJSON},
+		{"application/problem+json", errorFormatJSON},
+		{"text/plain", errorFormatText},
+		{"TEXT/PLAIN", errorFormatText},
+		{"*/*, application/json", errorFormatJSON},
+		{"*/*;q=0.1, text/plain", errorFormatText},
+		{"text/plain;q=0.5, application/json;q=0.9", errorFormatJSON},
+		{"text/html;q=0.5, text/plain", errorFormatText},
+		{"application/json;q=0, text/plain", errorFormatText},
+		{"application/json;q=0", errorFormatHTML},
+		{"application/json;q=abc", errorFormatJSON},
+		{"image/png", errorFormatHTML},
+	}
+	for _, test := range tests {
+		if got := negotiateError(test.accept); got != test.want {
+			t.Errorf("negotiateError(%q) = %q, want %q", test.accept, got, test.want)
+		}
+	}
+}
+
+func TestFlushErrorStatusText(t *testing.T) {
+	pages, err := NewErrorPages(map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{ErrorNotFound, "404 Not Found"},
+		{ErrorUnauthorized, "401 Unauthorized"},
+		{ErrorForbidden, "403 Forbidden"},
+		{ErrorGone, "410 Gone"},
+		{ErrorTooManyRequests, "429 Too Many Requests"},
+		{ErrorInternal, "500 Internal Server Error"},
+		{ErrorBadGateway, "502 Bad Gateway"},
+		{ErrorUnavailable, "503 Service Unavailable"},
+		{ErrorGatewayTimeout, "504 Gateway Timeout"},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		recorder := httptest.NewRecorder()
+		if err := pages.flushError(errors.Wrap(test.err, "test"), request, recorder); err != nil {
+			t.Fatal(err)
+		}
+		body := recorder.Body.String()
+		if !strings.Contains(body, "<title>"+test.want+"</title>") || !strings.Contains(body, "<h1>"+test.want+"</h1>") {
+			t.Errorf("%v: page does not show %q:\n%s", test.err, test.want, body)
+		}
+	}
+}
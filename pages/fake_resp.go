@@ -26,6 +26,9 @@ func (r *FakeResponse) CacheModeMiss() {
 func (r *FakeResponse) CacheModeHit() {
 	r.CacheMode("HIT")
 }
+func (r *FakeResponse) CacheModeShared() {
+	r.CacheMode("SHARED")
+}
 func (r *FakeResponse) CacheMode(mode string) {
 	r.SetHeader("Pages-Server-Cache", mode)
 }
@@ -2,25 +2,55 @@ package pages
 
 import (
 	"code.gitea.io/sdk/gitea"
+	"context"
 	"fmt"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
+	"html/template"
 	"io"
 	"net/http"
 	"net/url"
+	"time"
 )
 
 type GiteaConfig struct {
-	Server        string            `json:"server"`
-	Token         string            `json:"token"`
-	Client        *gitea.Client     `json:"-"`
-	Logger        *zap.Logger       `json:"-"`
-	CustomHeaders map[string]string `json:"custom_headers"`
-	CacheMaxSize  int               `json:"max_cache_size"`
+	Server           string        `json:"server"`
+	Tokens           *TokenSource  `json:"-"`
+	Breaker          *Breaker      `json:"-"`
+	HTTPClient       *http.Client  `json:"-"` // 附带 token 的 HTTP 客户端
+	Client           *gitea.Client `json:"-"`
+	Logger           *zap.Logger   `json:"-"`
+	Shared           *SharedCache  `json:"-"`
+	Metrics          *Metrics
+	Limiter          *Limiter
+	Missing          *NegativeCache     `json:"-"`
+	CustomHeaders    map[string]string  `json:"custom_headers"`
+	CacheMaxSize     int                `json:"max_cache_size"`
+	CleanURLs        bool               `json:"clean_urls"`
+	TrailingSlash    string             `json:"trailing_slash"`
+	IndexTemplate    *template.Template `json:"-"`
+	MarkdownTemplate *template.Template `json:"-"`
+	IndexFiles       []string           `json:"index_files"`
+	NotFoundFile     string             `json:"not_found_file"`
+	ProxyAllow       []string           `json:"proxy_allow"`
 }
 
-func (c *GiteaConfig) FileExists(domain *PageDomain, path string) (bool, error) {
-	context, err := c.OpenFileContext(domain, path)
+// api 返回绑定请求上下文的 SDK 客户端，请求随上下文取消并携带链路信息
+func (c *GiteaConfig) api(ctx context.Context) *gitea.Client {
+	client, err := gitea.NewClient(c.Server,
+		gitea.SetHTTPClient(c.HTTPClient),
+		gitea.SetGiteaVersion(""),
+		gitea.SetContext(ctx),
+	)
+	if err != nil {
+		return c.Client
+	}
+	return client
+}
+
+func (c *GiteaConfig) FileExists(ctx context.Context, domain *PageDomain, path string) (bool, error) {
+	context, err := c.OpenFileContext(ctx, domain, path)
 	if context != nil {
 		defer context.Body.Close()
 	}
@@ -32,16 +62,16 @@ func (c *GiteaConfig) FileExists(domain *PageDomain, path string) (bool, error)
 	return true, nil
 }
 
-func (c *GiteaConfig) ReadStringRepoFile(domain *PageDomain, path string) (string, error) {
-	data, err := c.ReadRepoFile(domain, path)
+func (c *GiteaConfig) ReadStringRepoFile(ctx context.Context, domain *PageDomain, path string) (string, error) {
+	data, err := c.ReadRepoFile(ctx, domain, path)
 	if err != nil {
 		return "", err
 	}
 	return string(data), nil
 }
 
-func (c *GiteaConfig) ReadRepoFile(domain *PageDomain, path string) ([]byte, error) {
-	context, err := c.OpenFileContext(domain, path)
+func (c *GiteaConfig) ReadRepoFile(ctx context.Context, domain *PageDomain, path string) ([]byte, error) {
+	context, err := c.OpenFileContext(ctx, domain, path)
 	if err != nil {
 		return nil, err
 	}
@@ -53,25 +83,31 @@ func (c *GiteaConfig) ReadRepoFile(domain *PageDomain, path string) ([]byte, err
 	return all, nil
 }
 
-func (c *GiteaConfig) OpenFileContext(domain *PageDomain, path string) (*http.Response, error) {
-	var (
-		giteaURL string
-		err      error
-	)
+func (c *GiteaConfig) OpenFileContext(ctx context.Context, domain *PageDomain, path string) (_ *http.Response, err error) {
+	ctx, span := startSpan(ctx, "pages.open_file",
+		append(domainAttributes(domain), attribute.String("pages.path", path))...)
+	defer func() { endSpan(span, err) }()
+	var giteaURL string
 	giteaURL, err = url.JoinPath(c.Server+"/api/v1/repos/", domain.Owner, domain.Repo, "media", path)
 	if err != nil {
 		return nil, err
 	}
 	giteaURL += "?ref=" + url.QueryEscape(domain.Branch)
-	req, err := http.NewRequest(http.MethodGet, giteaURL, nil)
+	originCtx, done := c.origin(ctx, "media")
+	req, err := http.NewRequestWithContext(originCtx, http.MethodGet, giteaURL, nil)
 	if err != nil {
+		done(0)
 		return nil, err
 	}
-	req.Header.Add("Authorization", "token "+c.Token)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
+		done(0)
 		return nil, errors.Wrap(err, "")
 	}
+	done(resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+	}
 	switch resp.StatusCode {
 	case http.StatusForbidden:
 		return nil, errors.Wrap(ErrorNotFound, "domain file not forbidden")
@@ -79,7 +115,33 @@ func (c *GiteaConfig) OpenFileContext(domain *PageDomain, path string) (*http.Re
 		return nil, errors.Wrap(ErrorNotFound, fmt.Sprintf("domain file not found: %s", path))
 	case http.StatusOK:
 	default:
-		return nil, errors.Wrap(ErrorInternal, fmt.Sprintf("unexpected status code '%d'", resp.StatusCode))
+		return nil, upstreamError(resp.StatusCode, fmt.Errorf("unexpected status code '%d'", resp.StatusCode))
 	}
 	return resp, nil
 }
+
+// accessClient 校验访客凭据使用的客户端，不附带服务端 token
+var accessClient = &http.Client{Timeout: 10 * time.Second}
+
+// CheckAccess 使用访客的凭据查询仓库，返回 Gitea 的响应状态码
+func (c *GiteaConfig) CheckAccess(ctx context.Context, domain *PageDomain, username, password string) (int, error) {
+	giteaURL, err := url.JoinPath(c.Server+"/api/v1/repos/", domain.Owner, domain.Repo)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, giteaURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.SetBasicAuth(username, password)
+	ctx, done := c.origin(ctx, "GetRepo")
+	injectTrace(ctx, req)
+	resp, err := accessClient.Do(req)
+	if err != nil {
+		done(0)
+		return 0, errors.Wrap(err, "")
+	}
+	defer resp.Body.Close()
+	done(resp.StatusCode)
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,195 @@
+package pages
+
+import (
+	"code.gitea.io/sdk/gitea"
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	healthCacheTime = 10 * time.Second
+	// healthTimeout 单次检查所有站点的时间上限，检查期间其他探针会等待结果
+	healthTimeout = 5 * time.Second
+)
+
+// healthAllowDefault 未配置 allow 时仅本机可以查看详细状态
+var healthAllowDefault = []string{"127.0.0.0/8", "::1/128"}
+
+// Health 健康检查与就绪检查
+type Health struct {
+	Path  string
+	Hosts []string // 仅在这些域名下提供，为空时所有域名均可访问
+	Allow []string // 可以查看详细状态的 IP 或 CIDR，其他访客只返回状态
+
+	mutex  sync.Mutex
+	last   time.Time
+	report *HealthReport
+}
+
+type HealthReport struct {
+	Status string        `json:"status"`
+	Sites  []*SiteHealth `json:"sites"`
+	Alias  AliasHealth   `json:"alias"`
+	Time   time.Time     `json:"time"`
+}
+
+type SiteHealth struct {
+	Domain  string        `json:"domain"`
+	Gitea   GiteaHealth   `json:"gitea"`
+	Token   TokenHealth   `json:"token"`
+	Cache   CacheHealth   `json:"cache"`
+	Breaker BreakerHealth `json:"breaker"`
+}
+
+type GiteaHealth struct {
+	Server    string `json:"server"`
+	Reachable bool   `json:"reachable"`
+	Version   string `json:"version,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type TokenHealth struct {
+	Configured bool            `json:"configured"`
+	Valid      bool            `json:"valid"`
+	Scopes     map[string]bool `json:"scopes,omitempty"`
+}
+
+type CacheHealth struct {
+	Owners  int  `json:"owners"`
+	Repos   int  `json:"repos"`
+	Files   int  `json:"files"`
+	Bytes   int  `json:"bytes"`
+	Missing int  `json:"missing"`
+	Shared  bool `json:"shared"`
+}
+
+type AliasHealth struct {
+	Count  int    `json:"count"`
+	File   string `json:"file,omitempty"`
+	Shared bool   `json:"shared"`
+}
+
+func (h *Health) matches(request *http.Request) bool {
+	if h == nil || (request.URL.Path != h.Path+"/healthz" && request.URL.Path != h.Path+"/readyz") {
+		return false
+	}
+	if len(h.Hosts) == 0 {
+		return true
+	}
+	host := strings.ToLower(strings.Split(request.Host, ":")[0])
+	return slices.Contains(h.Hosts, host)
+}
+
+// detailed 详细状态包含 Gitea 地址、token 权限与缓存信息，仅对允许的地址展示
+func (h *Health) detailed(request *http.Request) bool {
+	allow := h.Allow
+	if len(allow) == 0 {
+		allow = healthAllowDefault
+	}
+	return addressAllowed(allow, clientIP(request))
+}
+
+// serveHealth healthz 仅表示进程存活，readyz 会检查 Gitea 与 token 状态
+func (p *PageClient) serveHealth(writer http.ResponseWriter, request *http.Request) error {
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	writer.Header().Set("Cache-Control", "no-store")
+	if request.URL.Path == p.Health.Path+"/healthz" {
+		writer.WriteHeader(http.StatusOK)
+		return json.NewEncoder(writer).Encode(map[string]string{"status": "ok"})
+	}
+	report := p.Health.check(request.Context(), p)
+	if report.Status == "ok" {
+		writer.WriteHeader(http.StatusOK)
+	} else {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if !p.Health.detailed(request) {
+		return json.NewEncoder(writer).Encode(map[string]string{"status": report.Status})
+	}
+	return json.NewEncoder(writer).Encode(report)
+}
+
+// check 检查结果会缓存一段时间，避免探针请求频繁调用 Gitea
+func (h *Health) check(ctx context.Context, p *PageClient) *HealthReport {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.report != nil && time.Since(h.last) < healthCacheTime {
+		return h.report
+	}
+	// 结果由所有探针共享，不随单个探针取消
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), healthTimeout)
+	defer cancel()
+	report := &HealthReport{
+		Status: "ok",
+		Time:   time.Now(),
+		Alias: AliasHealth{
+			Count:  p.DomainAlias.Alias.Count(),
+			File:   p.DomainAlias.Local,
+			Shared: p.DomainAlias.Share,
+		},
+	}
+	for _, site := range p.Sites {
+		siteReport := site.check(ctx)
+		if !siteReport.Gitea.Reachable || (siteReport.Token.Configured && !siteReport.Token.Valid) {
+			report.Status = "unavailable"
+		}
+		report.Sites = append(report.Sites, siteReport)
+	}
+	h.report = report
+	h.last = time.Now()
+	return report
+}
+
+func (s *PageSite) check(ctx context.Context) *SiteHealth {
+	report := &SiteHealth{
+		Domain: s.BaseDomain,
+		Gitea: GiteaHealth{
+			Server: s.GiteaConfig.Server,
+		},
+		Token: TokenHealth{
+			Configured: s.GiteaConfig.Tokens.Len() > 0,
+		},
+	}
+	files, size := s.DomainCache.FileStats()
+	report.Cache = CacheHealth{
+		Owners:  s.OwnerCache.ItemCount(),
+		Repos:   s.DomainCache.ItemCount(),
+		Files:   files,
+		Bytes:   size,
+		Missing: s.GiteaConfig.Missing.ItemCount(),
+		Shared:  s.GiteaConfig.Shared != nil,
+	}
+	report.Breaker = s.GiteaConfig.Breaker.status()
+	originCtx, done := s.GiteaConfig.origin(ctx, "ServerVersion")
+	version, resp, err := s.GiteaConfig.api(originCtx).ServerVersion()
+	done(giteaStatus(resp))
+	if err != nil {
+		report.Gitea.Error = err.Error()
+		return report
+	}
+	report.Gitea.Reachable = true
+	report.Gitea.Version = version
+	if report.Token.Configured {
+		listOptions := gitea.ListOptions{PageSize: 1}
+		report.Token.Scopes = make(map[string]bool)
+		originCtx, done = s.GiteaConfig.origin(ctx, "GetMyUserInfo")
+		_, resp, _ = s.GiteaConfig.api(originCtx).GetMyUserInfo()
+		done(giteaStatus(resp))
+		report.Token.Valid = giteaStatus(resp) != 0 && giteaStatus(resp) != http.StatusUnauthorized
+		report.Token.Scopes["read:user"] = giteaStatus(resp) == http.StatusOK
+		originCtx, done = s.GiteaConfig.origin(ctx, "ListMyOrgs")
+		_, resp, _ = s.GiteaConfig.api(originCtx).ListMyOrgs(gitea.ListOrgsOptions{ListOptions: listOptions})
+		done(giteaStatus(resp))
+		report.Token.Scopes["read:organization"] = giteaStatus(resp) == http.StatusOK
+		originCtx, done = s.GiteaConfig.origin(ctx, "ListMyRepos")
+		_, resp, _ = s.GiteaConfig.api(originCtx).ListMyRepos(gitea.ListReposOptions{ListOptions: listOptions})
+		done(giteaStatus(resp))
+		report.Token.Scopes["read:repository"] = giteaStatus(resp) == http.StatusOK
+	}
+	return report
+}
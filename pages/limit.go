@@ -0,0 +1,123 @@
+package pages
+
+import (
+	"context"
+	"github.com/patrickmn/go-cache"
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// limiterIdle 长时间未使用的令牌桶会被回收
+const limiterIdle = 10 * time.Minute
+
+type RateLimit struct {
+	Rate  float64 `json:"rate"`  // 每秒请求数
+	Burst int     `json:"burst"` // 突发请求数
+}
+
+type RateLimitConfig struct {
+	Client      *RateLimit `json:"client,omitempty"`      // 每个客户端 IP 的请求
+	Owner       *RateLimit `json:"owner,omitempty"`       // 每个 owner 的回源请求
+	Concurrency int        `json:"concurrency,omitempty"` // 同时回源的最大数量
+}
+
+func (c *RateLimitConfig) Validate() error {
+	for name, limit := range map[string]*RateLimit{"client": c.Client, "owner": c.Owner} {
+		if limit != nil && (limit.Rate <= 0 || limit.Burst < 0) {
+			return errors.Errorf("invalid %s rate limit", name)
+		}
+	}
+	if c.Concurrency < 0 {
+		return errors.New("rate limit concurrency must not be negative")
+	}
+	return nil
+}
+
+// Limiter 限制客户端请求频率与 Gitea 回源，未配置时为 nil
+type Limiter struct {
+	config  *RateLimitConfig
+	clients *cache.Cache
+	owners  *cache.Cache
+	slots   chan struct{}
+}
+
+func NewLimiter(config *RateLimitConfig) *Limiter {
+	if config == nil {
+		return nil
+	}
+	result := &Limiter{
+		config:  config,
+		clients: cache.New(limiterIdle, limiterIdle),
+		owners:  cache.New(limiterIdle, limiterIdle),
+	}
+	if config.Concurrency > 0 {
+		result.slots = make(chan struct{}, config.Concurrency)
+	}
+	return result
+}
+
+func bucket(buckets *cache.Cache, key string, limit *RateLimit) *rate.Limiter {
+	if value, ok := buckets.Get(key); ok {
+		buckets.SetDefault(key, value)
+		return value.(*rate.Limiter)
+	}
+	burst := limit.Burst
+	if burst == 0 {
+		burst = int(math.Max(1, math.Ceil(limit.Rate)))
+	}
+	limiter := rate.NewLimiter(rate.Limit(limit.Rate), burst)
+	if err := buckets.Add(key, limiter, cache.DefaultExpiration); err != nil {
+		// 其他请求已创建
+		if value, ok := buckets.Get(key); ok {
+			return value.(*rate.Limiter)
+		}
+	}
+	return limiter
+}
+
+// allowClient 检查客户端 IP 的请求频率，超出时设置 Retry-After
+func (l *Limiter) allowClient(writer http.ResponseWriter, request *http.Request) error {
+	if l == nil || l.config.Client == nil {
+		return nil
+	}
+	reservation := bucket(l.clients, clientIP(request).String(), l.config.Client).Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		writer.Header().Set("Retry-After", retryAfter(delay))
+		return errors.Wrap(ErrorTooManyRequests, "client rate limit exceeded")
+	}
+	return nil
+}
+
+// acquire 回源前调用，检查 owner 的回源频率并占用一个并发名额
+func (l *Limiter) acquire(ctx context.Context, key string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	if l.config.Owner != nil && !bucket(l.owners, key, l.config.Owner).Allow() {
+		return nil, errors.Wrap(ErrorTooManyRequests, "owner rate limit exceeded")
+	}
+	if l.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "waiting for origin slot")
+	}
+}
+
+// acquire 按 Gitea 服务与 owner 限制回源
+func (c *GiteaConfig) acquire(ctx context.Context, owner string) (func(), error) {
+	return c.Limiter.acquire(ctx, c.Server+"|"+strings.ToLower(owner))
+}
+
+func retryAfter(delay time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(delay.Seconds())))
+}
@@ -0,0 +1,103 @@
+package pages
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"github.com/Masterminds/sprig/v3"
+	"github.com/patrickmn/go-cache"
+	"github.com/pkg/errors"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"html/template"
+	"io"
+	"path"
+	"strings"
+)
+
+// markdownTopic 将 Markdown 文件渲染为 HTML
+const markdownTopic = "pages-markdown"
+
+//go:embed markdown.gohtml
+var markdownPage string
+
+// markdownIndexFiles 开启 Markdown 渲染后追加的目录默认页面
+var markdownIndexFiles = []string{"index.md", "README.md"}
+
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+)
+
+type MarkdownMetadata struct {
+	Domain  *PageDomain
+	Path    string
+	Title   string
+	Content template.HTML
+}
+
+// NewMarkdownTemplate 未指定模板时使用内置模板
+func NewMarkdownTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = markdownPage
+	}
+	return template.New("markdown").Funcs(sprig.FuncMap()).Parse(text)
+}
+
+// renderMarkdown 使用布局模板渲染 Markdown，结果按提交缓存，超过缓存大小时不缓存
+func (receiver *DomainConfig) renderMarkdown(
+	client *GiteaConfig,
+	source *FakeResponse,
+	filePath string,
+) (*FakeResponse, error) {
+	defer source.Body.Close()
+	key := "markdown:" + filePath
+	result := receiver.newResponse(client, key)
+	result.ContentType("text/html; charset=utf-8")
+	if cached, _ := receiver.FileCache.Get(key); cached != nil {
+		page := cached.([]byte)
+		result.Body = NewByteBuf(page)
+		result.Length(len(page))
+		result.CacheModeHit()
+		return result, nil
+	}
+	data, err := io.ReadAll(source.Body)
+	if err != nil {
+		return nil, err
+	}
+	content := new(bytes.Buffer)
+	if err = markdown.Convert(data, content); err != nil {
+		return nil, errors.Wrap(err, "render markdown")
+	}
+	page := new(bytes.Buffer)
+	if err = client.MarkdownTemplate.Execute(page, &MarkdownMetadata{
+		Domain:  &receiver.PageDomain,
+		Path:    filePath,
+		Title:   markdownTitle(data, filePath),
+		Content: template.HTML(content.String()),
+	}); err != nil {
+		return nil, errors.Wrap(err, "render markdown layout")
+	}
+	result.Body = NewByteBuf(page.Bytes())
+	result.Length(page.Len())
+	if page.Len() > client.CacheMaxSize {
+		// 超过大小，不缓存
+		result.CacheModeIgnore()
+		return result, nil
+	}
+	receiver.FileCache.Set(key, page.Bytes(), cache.DefaultExpiration)
+	result.CacheModeMiss()
+	return result, nil
+}
+
+// markdownTitle 使用第一个一级标题，否则使用文件名
+func markdownTitle(data []byte, filePath string) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); strings.HasPrefix(line, "# ") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "# "))
+		}
+	}
+	return strings.TrimSuffix(path.Base(filePath), ".md")
+}
@@ -0,0 +1,171 @@
+package pages
+
+import (
+	"code.gitea.io/sdk/gitea"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+	"time"
+)
+
+// Metrics 通过 Caddy 的 metrics 注册表暴露 Prometheus 指标
+type Metrics struct {
+	requests      *prometheus.CounterVec
+	originCalls   *prometheus.CounterVec
+	originLatency *prometheus.HistogramVec
+	fetchErrors   *prometheus.CounterVec
+}
+
+const metricsNamespace = "gitea_pages"
+
+// RegisterMetrics 注册指标，多个站点共用同一组计数器
+func (p *PageClient) RegisterMetrics(registry prometheus.Registerer) error {
+	if registry == nil {
+		return nil
+	}
+	metrics := &Metrics{}
+	var err error
+	if metrics.requests, err = registerCollector(registry, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "requests_total",
+		Help:      "Pages requests by site, owner, status and cache mode.",
+	}, []string{"site", "owner", "status", "cache"})); err != nil {
+		return err
+	}
+	if metrics.originCalls, err = registerCollector(registry, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "origin_requests_total",
+		Help:      "Gitea API calls by endpoint and status code.",
+	}, []string{"endpoint", "code"})); err != nil {
+		return err
+	}
+	if metrics.originLatency, err = registerCollector(registry, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "origin_request_duration_seconds",
+		Help:      "Gitea API call latency by endpoint.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})); err != nil {
+		return err
+	}
+	if metrics.fetchErrors, err = registerCollector(registry, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "fetch_errors_total",
+		Help:      "Errors while fetching owner, repository or file data from Gitea.",
+	}, []string{"stage"})); err != nil {
+		return err
+	}
+	gauges := []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "alias_count",
+			Help:        "CNAME aliases known to the alias store.",
+			ConstLabels: prometheus.Labels{"domain": p.Sites[0].BaseDomain},
+		}, func() float64 { return float64(p.DomainAlias.Alias.Count()) }),
+	}
+	for _, site := range p.Sites {
+		gauges = append(gauges, site.gauges()...)
+		site.GiteaConfig.Metrics = metrics
+	}
+	for _, gauge := range gauges {
+		// 重载配置后替换旧的 gauge，避免继续读取旧实例的缓存
+		if err = replaceCollector(registry, gauge); err != nil {
+			return err
+		}
+	}
+	p.metrics = metrics
+	return nil
+}
+
+func (s *PageSite) gauges() []prometheus.Collector {
+	labels := prometheus.Labels{"domain": s.BaseDomain}
+	return []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "owner_cache_items",
+			Help:        "Owners held in the owner cache.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(s.OwnerCache.ItemCount()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "domain_cache_items",
+			Help:        "Repositories held in the domain cache.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(s.DomainCache.ItemCount()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "file_cache_items",
+			Help:        "Files held in the file cache.",
+			ConstLabels: labels,
+		}, func() float64 { items, _ := s.DomainCache.FileStats(); return float64(items) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "file_cache_bytes",
+			Help:        "Bytes held in the file cache.",
+			ConstLabels: labels,
+		}, func() float64 { _, size := s.DomainCache.FileStats(); return float64(size) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "negative_cache_items",
+			Help:        "Unknown owners and repositories held in the negative cache.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(s.GiteaConfig.Missing.ItemCount()) }),
+	}
+}
+
+func registerCollector[T prometheus.Collector](registry prometheus.Registerer, collector T) (T, error) {
+	err := registry.Register(collector)
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		if existing, ok := registered.ExistingCollector.(T); ok {
+			return existing, nil
+		}
+		return collector, nil
+	}
+	return collector, err
+}
+
+// replaceCollector 注册 collector，已存在同名的 collector 时先移除
+func replaceCollector(registry prometheus.Registerer, collector prometheus.Collector) error {
+	err := registry.Register(collector)
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		registry.Unregister(registered.ExistingCollector)
+		return registry.Register(collector)
+	}
+	return err
+}
+
+// request 按站点统计请求，未匹配站点的请求记为 unmatched，避免随机域名产生大量序列
+func (m *Metrics) request(site, owner string, status int, cacheMode string) {
+	if m == nil {
+		return
+	}
+	m.requests.WithLabelValues(site, owner, strconv.Itoa(status), cacheMode).Inc()
+}
+
+// origin 记录一次 Gitea 调用，code 为 0 表示请求未完成
+func (m *Metrics) origin(endpoint string, start time.Time, code int) {
+	if m == nil {
+		return
+	}
+	label := "error"
+	if code > 0 {
+		label = strconv.Itoa(code)
+	}
+	m.originCalls.WithLabelValues(endpoint, label).Inc()
+	m.originLatency.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+}
+
+func (m *Metrics) fetchError(stage string, err error) {
+	if m == nil || err == nil || errors.Is(err, ErrorNotFound) || errors.Is(err, ErrorTooManyRequests) {
+		return
+	}
+	m.fetchErrors.WithLabelValues(stage).Inc()
+}
+
+func giteaStatus(resp *gitea.Response) int {
+	if resp == nil || resp.Response == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
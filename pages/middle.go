@@ -1,17 +1,232 @@
 package pages
 
-import "time"
+import (
+	"encoding/json"
+	"github.com/alecthomas/units"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/pkg/errors"
+	"net/netip"
+	"strings"
+)
+
+const redactedValue = "REDACTED"
+
+// ByteSize 文件大小，JSON 中可使用字节数或 "1MB" 格式
+type ByteSize int
+
+func (s *ByteSize) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		var size int
+		if err = json.Unmarshal(data, &size); err != nil {
+			return err
+		}
+		*s = ByteSize(size)
+		return nil
+	}
+	size, err := units.ParseBase2Bytes(text)
+	if err != nil {
+		return err
+	}
+	*s = ByteSize(size)
+	return nil
+}
 
 type MiddlewareConfig struct {
-	Server        string            `json:"server"`
-	Token         string            `json:"token"`
-	Domain        string            `json:"domain"`
-	Alias         string            `json:"alias"`
-	CacheRefresh  time.Duration     `json:"cache_refresh"`
-	CacheTimeout  time.Duration     `json:"cache_timeout"`
-	ErrorPages    map[string]string `json:"errors"`
-	CustomHeaders map[string]string `json:"custom_headers"`
-	AutoRedirect  *AutoRedirect     `json:"redirect"`
-	SharedAlias   bool              `json:"shared_alias"`
-	CacheMaxSize  int               `json:"cache_max_size"`
+	Server        string               `json:"server"`
+	Token         string               `json:"token"`
+	Tokens        []string             `json:"tokens"` // 备用 token，401 时依次切换
+	Domain        string               `json:"domain"`
+	Alias         string               `json:"alias"`
+	CacheRefresh  caddy.Duration       `json:"cache_refresh"`
+	CacheTimeout  caddy.Duration       `json:"cache_timeout"`
+	ErrorPages    map[string]string    `json:"errors"`
+	ErrorRepo     *PageDomain          `json:"error_repo,omitempty"`   // 存放错误页面模板的仓库
+	DebugErrors   bool                 `json:"debug_errors,omitempty"` // 错误页面展示详细错误
+	CustomHeaders map[string]string    `json:"custom_headers"`
+	AutoRedirect  *AutoRedirect        `json:"redirect"`
+	SharedAlias   bool                 `json:"shared_alias"`
+	CacheMaxSize  ByteSize             `json:"cache_max_size"`
+	SharedCache   bool                 `json:"shared_cache"`
+	SharedPrefix  string               `json:"shared_prefix"`
+	Webhook       *Webhook             `json:"webhook"`
+	HealthPath    string               `json:"health_path"`
+	HealthHosts   []string             `json:"health_hosts,omitempty"` // 仅在这些域名下提供健康检查
+	HealthAllow   []string             `json:"health_allow,omitempty"` // 可以查看详细状态的地址
+	DefaultBranch string               `json:"default_branch"`
+	PrivatePolicy string               `json:"private_policy"`
+	RepoLookup    string               `json:"repo_lookup,omitempty"`
+	Canonical     bool                 `json:"canonical_redirect,omitempty"` // 仓库名称大小写不一致时跳转到规范地址
+	CleanURLs     bool                 `json:"clean_urls,omitempty"`
+	TrailingSlash string               `json:"trailing_slash,omitempty"`
+	AutoIndex     string               `json:"autoindex_template,omitempty"` // 目录索引模板
+	Markdown      string               `json:"markdown_template,omitempty"`  // Markdown 布局模板
+	IndexFiles    []string             `json:"index_files,omitempty"`        // 目录默认页面，按顺序查找
+	NotFoundFile  string               `json:"not_found_file,omitempty"`     // 各目录下的 404 页面名称
+	ProxyAllow    []string             `json:"proxy_allow,omitempty"`        // 允许仓库转发的上游地址
+	RateLimit     *RateLimitConfig     `json:"rate_limit,omitempty"`
+	NegativeCache *NegativeCacheConfig `json:"negative_cache,omitempty"`
+	Sites         []*SiteConfig        `json:"sites"`
+}
+
+// SiteConfig 额外的 Pages 根域名，未填写的字段继承全局配置
+type SiteConfig struct {
+	Domain        string        `json:"domain"`
+	Server        string        `json:"server,omitempty"`
+	Token         string        `json:"token,omitempty"`
+	Tokens        []string      `json:"tokens,omitempty"`
+	DefaultBranch string        `json:"default_branch,omitempty"`
+	AutoRedirect  *AutoRedirect `json:"redirect,omitempty"`
+	PrivatePolicy string        `json:"private_policy,omitempty"`
+}
+
+func (c *MiddlewareConfig) primarySite() *SiteConfig {
+	return &SiteConfig{
+		Domain:        c.Domain,
+		Server:        c.Server,
+		Token:         c.Token,
+		Tokens:        c.Tokens,
+		DefaultBranch: c.DefaultBranch,
+		AutoRedirect:  c.AutoRedirect,
+		PrivatePolicy: c.PrivatePolicy,
+	}
+}
+
+func (c *MiddlewareConfig) inheritSite(site *SiteConfig) *SiteConfig {
+	result := *site
+	if result.Server == "" {
+		result.Server = c.Server
+		if result.Token == "" && len(result.Tokens) == 0 {
+			result.Token = c.Token
+			result.Tokens = c.Tokens
+		}
+	}
+	if result.DefaultBranch == "" {
+		result.DefaultBranch = c.DefaultBranch
+	}
+	if result.AutoRedirect == nil {
+		result.AutoRedirect = c.AutoRedirect
+	}
+	if result.PrivatePolicy == "" {
+		result.PrivatePolicy = c.PrivatePolicy
+	}
+	return &result
+}
+
+func (c *MiddlewareConfig) Validate() error {
+	if c.Server == "" {
+		return errors.New("gitea server is required")
+	}
+	if c.Domain == "" {
+		return errors.New("pages domain is required")
+	}
+	if c.AutoRedirect != nil && c.AutoRedirect.Enabled && c.AutoRedirect.Scheme == "" {
+		return errors.New("redirect scheme is required")
+	}
+	if c.Webhook != nil && c.Webhook.Path == "" {
+		return errors.New("webhook path is required")
+	}
+	for _, item := range c.HealthAllow {
+		if _, err := netip.ParsePrefix(item); err != nil {
+			if _, err = netip.ParseAddr(item); err != nil {
+				return errors.Errorf("invalid health allow address '%s'", item)
+			}
+		}
+	}
+	if c.Webhook != nil && c.Webhook.Secret == "" {
+		return errors.New("webhook secret is required")
+	}
+	if err := validatePrivatePolicy(c.PrivatePolicy); err != nil {
+		return err
+	}
+	if c.ErrorRepo != nil && (c.ErrorRepo.Owner == "" || c.ErrorRepo.Repo == "") {
+		return errors.New("error pages repository requires owner and repo")
+	}
+	for _, name := range append([]string{c.NotFoundFile}, c.IndexFiles...) {
+		if strings.Contains(name, "/") {
+			return errors.Errorf("invalid page file name '%s'", name)
+		}
+	}
+	if !validateTrailingSlash(c.TrailingSlash) {
+		return errors.Errorf("unknown trailing slash mode '%s'", c.TrailingSlash)
+	}
+	switch c.RepoLookup {
+	case "", RepoLookupList, RepoLookupDirect:
+	default:
+		return errors.Errorf("unknown repo lookup mode '%s'", c.RepoLookup)
+	}
+	if c.NegativeCache != nil && (c.NegativeCache.TTL < 0 || c.NegativeCache.Size < 0) {
+		return errors.New("invalid negative cache config")
+	}
+	for _, upstream := range c.ProxyAllow {
+		if err := validateProxyUpstream(upstream); err != nil {
+			return err
+		}
+	}
+	if c.RateLimit != nil {
+		if err := c.RateLimit.Validate(); err != nil {
+			return err
+		}
+	}
+	domains := map[string]bool{strings.Trim(c.Domain, "."): true}
+	for _, site := range c.Sites {
+		if err := validatePrivatePolicy(site.PrivatePolicy); err != nil {
+			return err
+		}
+		domain := strings.Trim(site.Domain, ".")
+		if domain == "" {
+			return errors.New("site domain is required")
+		}
+		if domains[domain] {
+			return errors.Errorf("duplicate pages domain '%s'", domain)
+		}
+		domains[domain] = true
+	}
+	return nil
+}
+
+func validatePrivatePolicy(policy string) error {
+	switch policy {
+	case "", PrivatePublic, PrivateAuth, PrivateHide:
+		return nil
+	default:
+		return errors.Errorf("unknown private policy '%s'", policy)
+	}
+}
+
+// redacted 返回隐藏了密钥的配置副本，用于日志输出
+func (c *MiddlewareConfig) redacted() MiddlewareConfig {
+	result := *c
+	result.Token = redactToken(c.Token)
+	result.Tokens = redactTokens(c.Tokens)
+	if c.Webhook != nil {
+		webhook := *c.Webhook
+		if webhook.Secret != "" {
+			webhook.Secret = redactedValue
+		}
+		result.Webhook = &webhook
+	}
+	result.Sites = make([]*SiteConfig, 0, len(c.Sites))
+	for _, site := range c.Sites {
+		site := *site
+		site.Token = redactToken(site.Token)
+		site.Tokens = redactTokens(site.Tokens)
+		result.Sites = append(result.Sites, &site)
+	}
+	return result
+}
+
+func redactToken(token string) string {
+	if token == "" || isPlaceholder(token) {
+		return token
+	}
+	return redactedValue
+}
+
+func redactTokens(tokens []string) []string {
+	result := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		result = append(result, redactToken(token))
+	}
+	return result
 }
@@ -6,13 +6,14 @@ type PageDomain struct {
 	Owner  string `json:"owner"`
 	Repo   string `json:"repo"`
 	Branch string `json:"branch"`
+	Site   string `json:"site,omitempty"` // 所属站点，主站点为空
 }
 
 func NewPageDomain(owner string, repo string, branch string) *PageDomain {
 	return &PageDomain{
-		owner,
-		repo,
-		branch,
+		Owner:  owner,
+		Repo:   repo,
+		Branch: branch,
 	}
 }
 
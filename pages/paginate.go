@@ -0,0 +1,50 @@
+package pages
+
+import (
+	"code.gitea.io/sdk/gitea"
+	"context"
+	"go.uber.org/zap"
+)
+
+const (
+	// listPageSize Gitea 默认的最大分页大小 (MAX_RESPONSE_ITEMS)
+	listPageSize = 50
+	// listMaxPages 防止服务端忽略分页参数时无限请求
+	listMaxPages = 1000
+)
+
+// listAll 依次读取所有分页，每一页单独记录回源，返回最后一次请求的响应
+func listAll[T any](
+	ctx context.Context,
+	client *GiteaConfig,
+	endpoint string,
+	list func(api *gitea.Client, options gitea.ListOptions) ([]T, *gitea.Response, error),
+) ([]T, *gitea.Response, error) {
+	var result []T
+	var last *gitea.Response
+	page := 1
+	for i := 0; i < listMaxPages; i++ {
+		originCtx, done := client.origin(ctx, endpoint)
+		items, resp, err := list(client.api(originCtx), gitea.ListOptions{Page: page, PageSize: listPageSize})
+		done(giteaStatus(resp))
+		last = resp
+		if err != nil {
+			return nil, resp, err
+		}
+		result = append(result, items...)
+		if len(items) == 0 {
+			return result, resp, nil
+		}
+		if resp != nil && resp.NextPage > page {
+			page = resp.NextPage
+			continue
+		}
+		// 存在 Link 头时以其为准，否则按返回数量判断
+		if (resp != nil && resp.Header.Get("Link") != "") || len(items) < listPageSize {
+			return result, resp, nil
+		}
+		page++
+	}
+	client.Logger.Warn("too many pages, listing truncated.", zap.String("endpoint", endpoint))
+	return result, last, nil
+}
@@ -0,0 +1,86 @@
+package pages
+
+import (
+	"code.gitea.io/sdk/gitea"
+	"context"
+	"go.uber.org/zap"
+	"net/http"
+	"testing"
+)
+
+func TestListAll(t *testing.T) {
+	tests := []struct {
+		name  string
+		pages []int // 每页返回的数量
+		link  bool  // 返回 Link 头但没有下一页
+		want  int
+		calls int
+	}{
+		{name: "short page", pages: []int{listPageSize, listPageSize, 10}, want: 2*listPageSize + 10, calls: 3},
+		{name: "empty page", pages: []int{listPageSize, listPageSize, 0}, want: 2 * listPageSize, calls: 3},
+		{name: "empty first page", pages: []int{0}, want: 0, calls: 1},
+		{name: "single short page", pages: []int{1}, want: 1, calls: 1},
+		{name: "link header without next", pages: []int{listPageSize, listPageSize}, link: true, want: listPageSize, calls: 1},
+	}
+	client := &GiteaConfig{Logger: zap.NewNop()}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+			result, _, err := listAll(context.Background(), client, "test",
+				func(_ *gitea.Client, options gitea.ListOptions) ([]int, *gitea.Response, error) {
+					calls++
+					if options.Page != calls {
+						t.Fatalf("page = %d, want %d", options.Page, calls)
+					}
+					if calls > len(test.pages) {
+						t.Fatalf("unexpected request for page %d", options.Page)
+					}
+					header := http.Header{}
+					if test.link {
+						header.Set("Link", `<https://gitea.example.com/api/v1/orgs/o/repos?page=1>; rel="first"`)
+					}
+					return make([]int, test.pages[calls-1]), &gitea.Response{Response: &http.Response{Header: header}}, nil
+				})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result) != test.want || calls != test.calls {
+				t.Errorf("got %d items in %d calls, want %d items in %d calls", len(result), calls, test.want, test.calls)
+			}
+		})
+	}
+}
+
+func TestListAllNextPage(t *testing.T) {
+	var requested []int
+	result, _, err := listAll(context.Background(), &GiteaConfig{Logger: zap.NewNop()}, "test",
+		func(_ *gitea.Client, options gitea.ListOptions) ([]int, *gitea.Response, error) {
+			requested = append(requested, options.Page)
+			resp := &gitea.Response{Response: &http.Response{Header: http.Header{}}}
+			if options.Page == 1 {
+				resp.NextPage = 3
+			}
+			return make([]int, 5), resp, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 10 || len(requested) != 2 || requested[1] != 3 {
+		t.Errorf("got %d items from pages %v, want 10 items from [1 3]", len(result), requested)
+	}
+}
+
+func TestListAllTruncated(t *testing.T) {
+	calls := 0
+	result, _, err := listAll(context.Background(), &GiteaConfig{Logger: zap.NewNop()}, "test",
+		func(_ *gitea.Client, _ gitea.ListOptions) ([]int, *gitea.Response, error) {
+			calls++
+			return make([]int, listPageSize), &gitea.Response{Response: &http.Response{Header: http.Header{}}}, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != listMaxPages || len(result) != listMaxPages*listPageSize {
+		t.Errorf("got %d calls, want %d", calls, listMaxPages)
+	}
+}
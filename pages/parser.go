@@ -3,14 +3,35 @@ package pages
 import (
 	"fmt"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 	"net/http"
 	"strings"
 )
 
-func (p *PageClient) parseDomain(request *http.Request) (*PageDomain, string, error) {
+// canonicalLocation 请求路径中的仓库名称与实际大小写不一致时返回规范地址
+func canonicalLocation(request *http.Request, domain *PageDomain, filePath string) (string, bool) {
+	requestPath := request.URL.Path
+	if filePath == requestPath {
+		// 默认仓库或 CNAME，路径中不包含仓库名称
+		return "", false
+	}
+	repo := strings.Split(strings.TrimPrefix(requestPath, "/"), "/")[0]
+	if repo == domain.Repo || !strings.EqualFold(repo, domain.Repo) {
+		return "", false
+	}
+	location := "/" + domain.Repo + strings.TrimPrefix(requestPath, "/"+repo)
+	if request.URL.RawQuery != "" {
+		location += "?" + request.URL.RawQuery
+	}
+	return location, true
+}
+
+func (p *PageClient) parseDomain(request *http.Request) (_ *PageSite, _ *PageDomain, _ string, err error) {
+	ctx, span := startSpan(request.Context(), "pages.parse_domain", attribute.String("pages.host", request.Host))
+	defer func() { endSpan(span, err) }()
 	if strings.Contains(request.Host, "]") {
 		//跳过 ipv6 address 直接访问, 因为仅支持域名的方式
-		return nil, "", ErrorNotMatches
+		return nil, nil, "", ErrorNotMatches
 	}
 	host := strings.Split(request.Host, ":")[0]
 	filePath := request.URL.Path
@@ -20,31 +41,51 @@ func (p *PageClient) parseDomain(request *http.Request) (*PageDomain, string, er
 	if !strings.HasPrefix(filePath, fmt.Sprintf("/%s/", repo)) {
 		repo = ""
 	}
-	if strings.HasSuffix(host, p.BaseDomain) {
-		child := strings.Split(strings.TrimSuffix(host, p.BaseDomain), ".")
+	if site := p.matchSite(host); site != nil {
+		child := strings.Split(strings.TrimSuffix(host, site.BaseDomain), ".")
 		result := NewPageDomain(
 			child[len(child)-1],
 			repo,
-			"gh-pages",
+			site.DefaultBranch,
 		)
+		result.Site = site.Name
+		ownerRepoName := result.Owner + site.BaseDomain
+		state := getRequestState(request.Context())
+		state.Site = site
+		state.Owner = result.Owner
+		state.Repo = repo
+		if repo == "" {
+			state.Repo = ownerRepoName
+		}
 		// 处于使用默认 Domain 下
-		config, err := p.OwnerCache.GetOwnerConfig(p.GiteaConfig, result.Owner)
+		config, err := site.OwnerCache.GetOwnerConfig(ctx, site.GiteaConfig, result.Owner)
 		if err != nil {
-			return nil, "", err
+			return nil, nil, "", err
+		}
+		state.OwnerExists = true
+		found := false
+		if result.Repo != "" {
+			// 使用仓库的实际名称，避免不同大小写产生多份缓存
+			result.Repo, found, err = site.OwnerCache.LookupRepo(ctx, site.GiteaConfig, config, result.Owner, result.Repo)
+			if err != nil {
+				return nil, nil, "", err
+			}
 		}
-		ownerRepoName := result.Owner + p.BaseDomain
-		if result.Repo == "" && config.Exists(ownerRepoName) {
-			// 推导为默认仓库
-			result.Repo = ownerRepoName
-			return result, filePath, nil
-		} else if result.Repo == "" || !config.Exists(result.Repo) {
-			if config.Exists(ownerRepoName) {
-				result.Repo = ownerRepoName
-				return result, filePath, nil
+		if !found {
+			// 未指定 repo 或者 repo 不存在，推导为默认仓库
+			result.Repo, found, err = site.OwnerCache.LookupRepo(ctx, site.GiteaConfig, config, result.Owner, ownerRepoName)
+			if err != nil {
+				return nil, nil, "", err
+			}
+			if !found {
+				return nil, nil, "", errors.Wrap(ErrorNotFound, repo+" not found")
 			}
-			// 未指定 repo 或者 repo 不存在，跳过
-			return nil, "", errors.Wrap(ErrorNotFound, result.Repo+" not found")
+			state.Repo = result.Repo
+			state.RepoExists = true
+			return site, result, filePath, nil
 		}
+		state.Repo = result.Repo
+		state.RepoExists = true
 		// 存在子目录且仓库存在
 		pathTrim = pathTrim[1:]
 		path := ""
@@ -58,13 +99,17 @@ func (p *PageClient) parseDomain(request *http.Request) (*PageDomain, string, er
 		if path == "" {
 			path = "/"
 		}
-		return result, path, nil
+		return site, result, path, nil
 	} else {
 		get, exists := p.DomainAlias.Get(host)
-		if exists {
-			return &get, filePath, nil
+		if site := p.site(get.Site); exists && site != nil {
+			state := getRequestState(request.Context())
+			state.Site = site
+			state.Owner, state.Repo = get.Owner, get.Repo
+			state.OwnerExists, state.RepoExists = true, true
+			return site, &get, filePath, nil
 		} else {
-			return nil, "", errors.Wrap(ErrorNotFound, "")
+			return nil, nil, "", errors.Wrap(ErrorNotFound, "")
 		}
 	}
 }
@@ -0,0 +1,182 @@
+package pages
+
+import (
+	"bufio"
+	"context"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// redirectsFile Netlify 格式的重写规则，仅支持状态码为 200 的外部地址
+const redirectsFile = "/_redirects"
+
+// ProxyRule 将匹配的路径转发到外部地址
+type ProxyRule struct {
+	Path string `yaml:"path" json:"path"` // 以 /* 结尾时匹配子路径
+	To   string `yaml:"to" json:"to"`     // 可使用 :splat 引用匹配的子路径
+}
+
+// loadProxy 读取 .pages.yaml 与 _redirects 中的转发规则，未配置上游白名单时跳过
+func loadProxy(ctx context.Context, client *GiteaConfig, domain *PageDomain, repoConfig *RepoConfig, result *DomainConfig) error {
+	result.Proxy = nil
+	if len(client.ProxyAllow) == 0 {
+		return nil
+	}
+	rules := append([]*ProxyRule{}, repoConfig.Proxy...)
+	data, err := client.ReadRepoFile(ctx, domain, result.BasePath+redirectsFile)
+	if err != nil && !errors.Is(err, ErrorNotFound) {
+		return err
+	}
+	rules = append(rules, parseRedirects(data)...)
+	for _, rule := range rules {
+		if rule == nil || !strings.HasPrefix(rule.Path, "/") {
+			continue
+		}
+		if _, err := url.Parse(rule.To); err != nil {
+			client.Logger.Warn("invalid proxy rule, ignored.",
+				zap.String("repo", domain.Key()), zap.String("to", rule.To), zap.Error(err))
+			continue
+		}
+		result.Proxy = append(result.Proxy, rule)
+	}
+	return nil
+}
+
+// parseRedirects 解析 _redirects，忽略跳转等其他规则
+func parseRedirects(data []byte) []*ProxyRule {
+	var result []*ProxyRule
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if strings.TrimSuffix(fields[2], "!") != "200" {
+			continue
+		}
+		if !strings.HasPrefix(fields[1], "http://") && !strings.HasPrefix(fields[1], "https://") {
+			continue
+		}
+		result = append(result, &ProxyRule{Path: fields[0], To: fields[1]})
+	}
+	return result
+}
+
+// target 返回请求路径对应的上游地址，不匹配时返回 nil
+func (r *ProxyRule) target(filePath string) *url.URL {
+	filePath = path.Clean("/" + filePath)
+	var splat string
+	if prefix, ok := strings.CutSuffix(r.Path, "*"); ok {
+		if filePath+"/" == prefix {
+			splat = ""
+		} else if rest, found := strings.CutPrefix(filePath, prefix); found {
+			splat = rest
+		} else {
+			return nil
+		}
+	} else if path.Clean(r.Path) != filePath {
+		return nil
+	}
+	// 逐段转义，避免子路径中的 ? 与 # 改变上游地址的查询参数
+	segments := strings.Split(splat, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	splat = strings.Join(segments, "/")
+	to := r.To
+	if strings.Contains(to, ":splat") {
+		to = strings.ReplaceAll(to, ":splat", splat)
+	} else if splat != "" {
+		to = strings.TrimSuffix(to, "/") + "/" + splat
+	}
+	result, err := url.Parse(to)
+	if err != nil {
+		return nil
+	}
+	return result
+}
+
+// matchProxy 按顺序查找转发规则，上游不在白名单内的规则被忽略
+func (c *GiteaConfig) matchProxy(config *DomainConfig, filePath string) *url.URL {
+	for _, rule := range config.Proxy {
+		target := rule.target(filePath)
+		if target == nil {
+			continue
+		}
+		if c.proxyAllowed(target) {
+			return target
+		}
+		c.Logger.Debug("proxy upstream not allowed.",
+			zap.String("repo", config.PageDomain.Key()), zap.String("upstream", target.Host))
+	}
+	return nil
+}
+
+// proxyAllowed 上游白名单按协议与主机匹配，主机支持 *.example.com 通配
+func (c *GiteaConfig) proxyAllowed(target *url.URL) bool {
+	for _, item := range c.ProxyAllow {
+		allowed, err := url.Parse(item)
+		if err != nil || !strings.EqualFold(allowed.Scheme, target.Scheme) {
+			continue
+		}
+		host := strings.ToLower(target.Host)
+		pattern := strings.ToLower(allowed.Host)
+		if host == pattern {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok && strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxy 将请求转发到上游，双向移除 Cookie 与访客凭据
+func (s *PageSite) proxy(writer http.ResponseWriter, request *http.Request, target *url.URL) error {
+	var proxyErr error
+	query := request.URL.RawQuery
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.Out.URL = target
+			r.Out.Host = target.Host
+			if target.RawQuery == "" || query == "" {
+				r.Out.URL.RawQuery = target.RawQuery + query
+			} else {
+				r.Out.URL.RawQuery = target.RawQuery + "&" + query
+			}
+			// 同一域名下的其他仓库共享 Cookie 与浏览器缓存的 Basic 凭据，不转发到上游
+			r.Out.Header.Del("Cookie")
+			r.Out.Header.Del("Authorization")
+			r.Out.Header.Del("Proxy-Authorization")
+			r.SetXForwarded()
+		},
+		ModifyResponse: func(response *http.Response) error {
+			response.Header.Del("Set-Cookie")
+			return nil
+		},
+		ErrorHandler: func(_ http.ResponseWriter, _ *http.Request, err error) {
+			proxyErr = err
+		},
+	}
+	proxy.ServeHTTP(writer, request)
+	if proxyErr != nil {
+		return errors.Wrapf(ErrorBadGateway, "proxy to %s: %v", target.Host, proxyErr)
+	}
+	return nil
+}
+
+func validateProxyUpstream(upstream string) error {
+	result, err := url.Parse(upstream)
+	if err != nil {
+		return errors.Wrapf(err, "invalid proxy upstream '%s'", upstream)
+	}
+	if (result.Scheme != "http" && result.Scheme != "https") || result.Host == "" {
+		return errors.Errorf("invalid proxy upstream '%s'", upstream)
+	}
+	return nil
+}
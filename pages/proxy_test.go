@@ -0,0 +1,110 @@
+package pages
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestProxyRuleTarget(t *testing.T) {
+	tests := []struct {
+		rule ProxyRule
+		path string
+		want string // 为空时表示不匹配
+	}{
+		{ProxyRule{"/api/*", "https://api.example.com/:splat"}, "/api/users/1", "https://api.example.com/users/1"},
+		{ProxyRule{"/api/*", "https://api.example.com/:splat"}, "/api", "https://api.example.com/"},
+		{ProxyRule{"/api/*", "https://api.example.com/:splat"}, "/api/", "https://api.example.com/"},
+		{ProxyRule{"/api/*", "https://api.example.com/:splat"}, "/apix", ""},
+		{ProxyRule{"/api/*", "https://api.example.com/:splat"}, "/api/../etc", ""},
+		{ProxyRule{"/api/*", "https://api.example.com/:splat"}, "/api/a?b#c", "https://api.example.com/a%3Fb%23c"},
+		{ProxyRule{"/api/*", "https://api.example.com/:splat"}, "/api/a b/c", "https://api.example.com/a%20b/c"},
+		{ProxyRule{"/api/*", "https://api.example.com/:splat?key=1"}, "/api/a?b", "https://api.example.com/a%3Fb?key=1"},
+		{ProxyRule{"/api/*", "https://api.example.com/v1"}, "/api/users", "https://api.example.com/v1/users"},
+		{ProxyRule{"/api/*", "https://api.example.com/v1/"}, "/api/a#b", "https://api.example.com/v1/a%23b"},
+		{ProxyRule{"/health", "https://status.example.com/ping"}, "/health", "https://status.example.com/ping"},
+		{ProxyRule{"/health", "https://status.example.com/ping"}, "/health/x", ""},
+	}
+	for _, test := range tests {
+		target := test.rule.target(test.path)
+		got := ""
+		if target != nil {
+			got = target.String()
+		}
+		if got != test.want {
+			t.Errorf("%s -> %s: target(%q) = %q, want %q", test.rule.Path, test.rule.To, test.path, got, test.want)
+		}
+	}
+}
+
+func TestProxyAllowed(t *testing.T) {
+	client := &GiteaConfig{ProxyAllow: []string{
+		"https://api.example.com",
+		"https://*.cdn.example.com",
+		"http://plain.example.com",
+	}}
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"https://api.example.com/users", true},
+		{"https://API.Example.com/users", true},
+		{"http://api.example.com/users", false},
+		{"https://api.example.com:8443/users", false},
+		{"https://api.example.com.evil.com/", false},
+		{"https://a.cdn.example.com/x", true},
+		{"https://a.b.cdn.example.com/x", true},
+		{"https://cdn.example.com/x", false},
+		{"https://evilcdn.example.com/x", false},
+		{"http://a.cdn.example.com/x", false},
+		{"http://plain.example.com/", true},
+		{"https://plain.example.com/", false},
+		{"https://evil.com/", false},
+	}
+	for _, test := range tests {
+		target, err := url.Parse(test.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := client.proxyAllowed(target); got != test.want {
+			t.Errorf("proxyAllowed(%q) = %v, want %v", test.target, got, test.want)
+		}
+	}
+}
+
+func TestProxyHeaders(t *testing.T) {
+	var received http.Header
+	var query string
+	upstream := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		received = request.Header.Clone()
+		query = request.URL.RawQuery
+		http.SetCookie(writer, &http.Cookie{Name: "session", Value: "upstream"})
+		writer.Header().Set("X-Upstream", "yes")
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL + "/v1/users?key=1")
+	request := httptest.NewRequest(http.MethodGet, "https://alice.example.com/api/users?page=2", nil)
+	request.SetBasicAuth("alice", "gitea-password")
+	request.Header.Set("Cookie", "other-repo=secret")
+	request.Header.Set("Proxy-Authorization", "Basic eDp5")
+	request.Header.Set("Accept", "application/json")
+	writer := httptest.NewRecorder()
+	if err := (&PageSite{}).proxy(writer, request, target); err != nil {
+		t.Fatal(err)
+	}
+	for _, header := range []string{"Authorization", "Cookie", "Proxy-Authorization"} {
+		if value := received.Get(header); value != "" {
+			t.Errorf("upstream received %s: %q", header, value)
+		}
+	}
+	if received.Get("Accept") != "application/json" || query != "key=1&page=2" {
+		t.Errorf("upstream received Accept %q and query %q", received.Get("Accept"), query)
+	}
+	if value := writer.Header().Get("Set-Cookie"); value != "" {
+		t.Errorf("visitor received Set-Cookie: %q", value)
+	}
+	if writer.Header().Get("X-Upstream") != "yes" {
+		t.Error("other upstream headers must be kept")
+	}
+}
@@ -0,0 +1,44 @@
+package pages
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// repoConfigFile 仓库内的 Pages 配置文件
+const repoConfigFile = "/.pages.yaml"
+
+// RepoConfig 仓库内 .pages.yaml 的内容
+type RepoConfig struct {
+	Access        *AccessConfig `yaml:"access" json:"-"` // 保存在 DomainConfig.Access
+	CleanURLs     *bool         `yaml:"clean_urls" json:"clean_urls,omitempty"`
+	TrailingSlash string        `yaml:"trailing_slash" json:"trailing_slash,omitempty"`
+	AutoIndex     bool          `yaml:"autoindex" json:"autoindex,omitempty"`
+	Markdown      bool          `yaml:"markdown" json:"markdown,omitempty"`
+	Templates     bool          `yaml:"templates" json:"templates,omitempty"`
+	Index         []string      `yaml:"index" json:"index,omitempty"`         // 目录默认页面，按顺序查找
+	NotFound      string        `yaml:"not_found" json:"not_found,omitempty"` // 404 页面名称
+	Routes        string        `yaml:"routes" json:"routes,omitempty"`       // SPA 路由模式 history 或 hash
+	Fallback      string        `yaml:"fallback" json:"fallback,omitempty"`   // history 路由的回退页面
+	Proxy         []*ProxyRule  `yaml:"proxy" json:"-"`                       // 保存在 DomainConfig.Proxy
+}
+
+// loadRepoConfig 读取仓库配置，文件不存在时返回空配置，解析失败时返回错误
+func loadRepoConfig(ctx context.Context, client *GiteaConfig, domain *PageDomain, basePath string) (*RepoConfig, error) {
+	result := &RepoConfig{}
+	data, err := client.ReadRepoFile(ctx, domain, basePath+repoConfigFile)
+	if errors.Is(err, ErrorNotFound) {
+		return result, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = yaml.Unmarshal(data, result); err != nil {
+		// 忽略配置会丢失访问控制，不能继续提供页面
+		client.Logger.Warn("invalid repository config.",
+			zap.String("repo", domain.Key()), zap.Error(err))
+		return nil, errors.Wrapf(ErrorInternal, "invalid %s: %v", repoConfigFile, err)
+	}
+	return result, nil
+}
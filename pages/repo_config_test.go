@@ -0,0 +1,51 @@
+package pages
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoadRepoConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string // 为空时文件不存在
+		want   int    // 期望的错误状态码，0 表示成功
+		access bool
+	}{
+		{name: "missing", config: ""},
+		{name: "valid", config: "access:\n  htpasswd: .htpasswd\nmarkdown: true\n", access: true},
+		{name: "invalid yaml with access", config: "access:\n  htpasswd: .htpasswd\n allow: [10.0.0.0/8\n", want: http.StatusInternalServerError},
+		{name: "invalid access type", config: "access: enabled\n", want: http.StatusInternalServerError},
+		{name: "invalid field type", config: "access:\n  allow: 10.0.0.1\nindex: {a: b}\n", want: http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				if test.config == "" || request.URL.Path != "/api/v1/repos/alice/blog/media/.pages.yaml" {
+					writer.WriteHeader(http.StatusNotFound)
+					return
+				}
+				_, _ = writer.Write([]byte(test.config))
+			}))
+			defer server.Close()
+			client := &GiteaConfig{Server: server.URL, HTTPClient: server.Client(), Logger: zap.NewNop()}
+			result, err := loadRepoConfig(context.Background(), client, NewPageDomain("alice", "blog", "gh-pages"), "")
+			if test.want != 0 {
+				// 解析失败时不能返回空配置，否则访问控制会失效
+				if err == nil || errorStatus(err) != test.want || result != nil {
+					t.Errorf("loadRepoConfig() = %v, %v, want status %d", result, err, test.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (result.Access != nil) != test.access {
+				t.Errorf("access = %v, want %v", result.Access, test.access)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package pages
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requestState 单次请求的路由信息，用于指标统计与访问日志
+type requestState struct {
+	Domain *PageDomain
+	Path   string
+	SHA    string
+	Start  time.Time
+	Origin time.Duration // 回源耗时
+
+	// 错误页面使用的信息
+	Site        *PageSite
+	Owner       string
+	Repo        string
+	OwnerExists bool
+	RepoExists  bool
+}
+
+type requestStateKey struct{}
+
+func withRequestState(request *http.Request) (*http.Request, *requestState) {
+	state := &requestState{Start: time.Now()}
+	return request.WithContext(context.WithValue(request.Context(), requestStateKey{}, state)), state
+}
+
+func getRequestState(ctx context.Context) *requestState {
+	if state, ok := ctx.Value(requestStateKey{}).(*requestState); ok {
+		return state
+	}
+	return &requestState{}
+}
+
+// responseRecorder 记录响应状态码和大小
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func newResponseRecorder(writer http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: writer}
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (p *PageClient) observe(recorder *responseRecorder, request *http.Request, state *requestState) {
+	if recorder.status == 0 {
+		// 未处理的请求
+		return
+	}
+	owner, repo, branch := "", "", ""
+	if state.Domain != nil {
+		owner, repo, branch = state.Domain.Owner, state.Domain.Repo, state.Domain.Branch
+	}
+	host := strings.Split(request.Host, ":")[0]
+	cacheMode := recorder.Header().Get("Pages-Server-Cache")
+	site := "unmatched"
+	if state.Site != nil {
+		site = strings.TrimPrefix(state.Site.BaseDomain, ".")
+	}
+	p.metrics.request(site, owner, recorder.status, cacheMode)
+	p.accessLogger.Info("handled request",
+		zap.String("host", host),
+		zap.String("method", request.Method),
+		zap.String("uri", request.RequestURI),
+		zap.Int("status", recorder.status),
+		zap.String("owner", owner),
+		zap.String("repo", repo),
+		zap.String("branch", branch),
+		zap.String("sha", state.SHA),
+		zap.String("path", state.Path),
+		zap.String("cache", cacheMode),
+		zap.Int64("bytes", recorder.bytes),
+		zap.Duration("origin_latency", state.Origin),
+		zap.Duration("duration", time.Since(state.Start)),
+	)
+}
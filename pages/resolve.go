@@ -0,0 +1,126 @@
+package pages
+
+import (
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+// 访问目录但缺少末尾 / 时的处理方式
+const (
+	TrailingSlashRedirect = "redirect" // 跳转到 /path/ (默认，与 GitHub Pages 一致)
+	TrailingSlashServe    = "serve"    // 直接返回 /path/index.html
+)
+
+// SPA 路由模式
+const (
+	RouteHistory = "history" // 没有扩展名或接受 HTML 的请求回退到入口页面
+	RouteHash    = "hash"    // 路由在 # 之后，不需要回退
+)
+
+const (
+	defaultIndexFile    = "index.html"
+	defaultNotFoundFile = "404.html"
+)
+
+type candidate struct {
+	path     string
+	redirect string // 文件存在时跳转到此地址 (相对地址)
+	render   bool   // 渲染 Markdown
+}
+
+// candidates 按 path、path.html、path.md、path/index.html 的顺序查找文件，带扩展名的路径只查找本身
+func (receiver *DomainConfig) candidates(client *GiteaConfig, filePath string) []candidate {
+	cleanURLs := client.CleanURLs
+	trailingSlash := client.TrailingSlash
+	if config := receiver.RepoConfig; config != nil {
+		if config.CleanURLs != nil {
+			cleanURLs = *config.CleanURLs
+		}
+		if config.TrailingSlash != "" {
+			trailingSlash = config.TrailingSlash
+		}
+	}
+	indexFiles := receiver.indexFiles(client)
+	if strings.HasSuffix(filePath, "/") {
+		result := make([]candidate, 0, len(indexFiles))
+		for _, index := range indexFiles {
+			result = append(result, candidate{path: filePath + index, render: receiver.renders(index)})
+		}
+		return result
+	}
+	base := path.Base(filePath)
+	if cleanURLs && strings.HasSuffix(filePath, ".html") {
+		// 去除 .html 后缀
+		target := strings.TrimSuffix(base, ".html")
+		if target == "index" {
+			target = "./"
+		}
+		return []candidate{{path: filePath, redirect: target}}
+	}
+	if path.Ext(base) != "" {
+		// 带扩展名的通常是资源文件，不再尝试其他路径，避免每次缺失都多次回源
+		return []candidate{{path: filePath}}
+	}
+	result := []candidate{{path: filePath}, {path: filePath + ".html"}}
+	if receiver.Markdown {
+		result = append(result, candidate{path: filePath + ".md", render: true})
+	}
+	for _, index := range indexFiles {
+		if trailingSlash == TrailingSlashServe {
+			result = append(result, candidate{path: filePath + "/" + index, render: receiver.renders(index)})
+		} else {
+			result = append(result, candidate{path: filePath + "/" + index, redirect: base + "/"})
+		}
+	}
+	return result
+}
+
+// indexFiles 目录的默认页面，仓库配置优先
+func (receiver *DomainConfig) indexFiles(client *GiteaConfig) []string {
+	result := []string{defaultIndexFile}
+	if receiver.RepoConfig != nil && len(receiver.RepoConfig.Index) > 0 {
+		result = receiver.RepoConfig.Index
+	} else if len(client.IndexFiles) > 0 {
+		result = client.IndexFiles
+	}
+	if receiver.Markdown {
+		return append(slices.Clip(result), markdownIndexFiles...)
+	}
+	return result
+}
+
+// renders 通过默认页面访问的 Markdown 文件需要渲染
+func (receiver *DomainConfig) renders(name string) bool {
+	return receiver.Markdown && strings.HasSuffix(name, ".md")
+}
+
+// notFoundFile 各目录下的 404 页面名称，仓库配置优先
+func (receiver *DomainConfig) notFoundFile(client *GiteaConfig) string {
+	if receiver.RepoConfig != nil && receiver.RepoConfig.NotFound != "" {
+		return receiver.RepoConfig.NotFound
+	}
+	if client.NotFoundFile != "" {
+		return client.NotFoundFile
+	}
+	return defaultNotFoundFile
+}
+
+// acceptsFallback history 模式下仅页面请求回退，缺失的 js、css 等资源仍然返回 404
+func acceptsFallback(filePath string, request *http.Request) bool {
+	return path.Ext(filePath) == "" || strings.Contains(request.Header.Get("Accept"), "text/html")
+}
+
+func redirectResponse(location string) *FakeResponse {
+	result := NewFakeResponse()
+	result.StatusCode = http.StatusMovedPermanently
+	result.SetHeader("Location", location)
+	result.Body = NewByteBuf(nil)
+	result.Length(0)
+	return result
+}
+
+func validateTrailingSlash(value string) bool {
+	return value == "" || value == TrailingSlashRedirect || value == TrailingSlashServe
+}
@@ -0,0 +1,207 @@
+package pages
+
+import (
+	"context"
+	"github.com/patrickmn/go-cache"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCandidates(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		name   string
+		client GiteaConfig
+		config DomainConfig
+		path   string
+		want   []candidate
+	}{
+		{
+			name: "root",
+			path: "/",
+			want: []candidate{{path: "/index.html"}},
+		},
+		{
+			name: "directory redirect",
+			path: "/docs",
+			want: []candidate{{path: "/docs"}, {path: "/docs.html"}, {path: "/docs/index.html", redirect: "docs/"}},
+		},
+		{
+			name:   "directory serve",
+			client: GiteaConfig{TrailingSlash: TrailingSlashServe},
+			path:   "/docs",
+			want:   []candidate{{path: "/docs"}, {path: "/docs.html"}, {path: "/docs/index.html"}},
+		},
+		{
+			name:   "markdown",
+			config: DomainConfig{Markdown: true},
+			path:   "/guide",
+			want: []candidate{
+				{path: "/guide"}, {path: "/guide.html"}, {path: "/guide.md", render: true},
+				{path: "/guide/index.html", redirect: "guide/"},
+				{path: "/guide/index.md", redirect: "guide/"},
+				{path: "/guide/README.md", redirect: "guide/"},
+			},
+		},
+		{
+			name:   "markdown directory",
+			config: DomainConfig{Markdown: true},
+			path:   "/guide/",
+			want: []candidate{
+				{path: "/guide/index.html"}, {path: "/guide/index.md", render: true}, {path: "/guide/README.md", render: true},
+			},
+		},
+		{
+			name: "asset",
+			path: "/x/y/app.js",
+			want: []candidate{{path: "/x/y/app.js"}},
+		},
+		{
+			name:   "asset with markdown",
+			config: DomainConfig{Markdown: true},
+			path:   "/x/y/style.css",
+			want:   []candidate{{path: "/x/y/style.css"}},
+		},
+		{
+			name: "html without clean urls",
+			path: "/page.html",
+			want: []candidate{{path: "/page.html"}},
+		},
+		{
+			name:   "clean urls",
+			client: GiteaConfig{CleanURLs: true},
+			path:   "/docs/page.html",
+			want:   []candidate{{path: "/docs/page.html", redirect: "page"}},
+		},
+		{
+			name:   "clean urls index",
+			client: GiteaConfig{CleanURLs: true},
+			path:   "/docs/index.html",
+			want:   []candidate{{path: "/docs/index.html", redirect: "./"}},
+		},
+		{
+			name:   "repository overrides",
+			client: GiteaConfig{CleanURLs: true, IndexFiles: []string{"default.html"}},
+			config: DomainConfig{RepoConfig: &RepoConfig{CleanURLs: &disabled, Index: []string{"home.html"}}},
+			path:   "/page.html",
+			want:   []candidate{{path: "/page.html"}},
+		},
+		{
+			name:   "repository index",
+			client: GiteaConfig{IndexFiles: []string{"default.html"}},
+			config: DomainConfig{RepoConfig: &RepoConfig{Index: []string{"home.html", "index.htm"}}},
+			path:   "/docs/",
+			want:   []candidate{{path: "/docs/home.html"}, {path: "/docs/index.htm"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.config.candidates(&test.client, test.path); !slices.Equal(got, test.want) {
+				t.Errorf("candidates(%q) = %+v, want %+v", test.path, got, test.want)
+			}
+		})
+	}
+}
+
+func TestAcceptsFallback(t *testing.T) {
+	tests := []struct {
+		path   string
+		accept string
+		want   bool
+	}{
+		{"/about", "", true},
+		{"/users/42", "*/*", true},
+		{"/about/", "", true},
+		{"/app.js", "*/*", false},
+		{"/styles/site.css", "text/css,*/*;q=0.1", false},
+		{"/logo.png", "image/avif,image/webp,*/*", false},
+		{"/v1.2", "text/html,application/xhtml+xml,*/*;q=0.8", true},
+		{"/old-page.php", "text/html", true},
+		{"/data.json", "application/json", false},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if test.accept != "" {
+			request.Header.Set("Accept", test.accept)
+		}
+		if got := acceptsFallback(test.path, request); got != test.want {
+			t.Errorf("acceptsFallback(%q, %q) = %v, want %v", test.path, test.accept, got, test.want)
+		}
+	}
+}
+
+func TestNotFoundPage(t *testing.T) {
+	files := map[string]string{
+		"/index.html":      "home",
+		"/404.html":        "root 404",
+		"/docs/404.html":   "docs 404",
+		"/docs/a/404.html": "docs a 404",
+		"/docs/a/404.md":   "# markdown 404",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, ok := files[strings.TrimPrefix(request.URL.Path, "/api/v1/repos/alice/blog/media")]
+		if !ok {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = io.WriteString(writer, body)
+	}))
+	defer server.Close()
+	client := &GiteaConfig{Server: server.URL, HTTPClient: server.Client(), Logger: zap.NewNop(), CacheMaxSize: 1 << 20}
+	tests := []struct {
+		name     string
+		fallback string
+		notFound string
+		repo     *RepoConfig
+		path     string
+		accept   string
+		want     string // 为空时期望 ErrorNotFound
+		status   int
+	}{
+		{name: "directory 404", notFound: "/404.html", path: "/docs/missing", want: "docs 404", status: 404},
+		{name: "nearest directory", notFound: "/404.html", path: "/docs/a/b/missing", want: "docs a 404", status: 404},
+		{name: "root 404", notFound: "/404.html", path: "/other/missing", want: "root 404", status: 404},
+		{name: "no root 404", path: "/other/missing"},
+		{name: "repository 404 name", notFound: "/404.html", repo: &RepoConfig{NotFound: "404.md"}, path: "/docs/a/x", want: "# markdown 404", status: 404},
+		{name: "history fallback", fallback: "/index.html", notFound: "/404.html", path: "/users/42", want: "home", status: 200},
+		{name: "history asset miss", fallback: "/index.html", notFound: "/404.html", path: "/docs/app.js", accept: "*/*", want: "docs 404", status: 404},
+		{name: "history html request", fallback: "/index.html", path: "/old.php", accept: "text/html", want: "home", status: 200},
+		{name: "missing fallback", fallback: "/app.html", notFound: "/404.html", path: "/users/42", want: "root 404", status: 404},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &DomainConfig{
+				PageDomain: *NewPageDomain("alice", "blog", "gh-pages"),
+				SHA:        "0123456789",
+				FileCache:  cache.New(time.Minute, time.Minute),
+				Fallback:   test.fallback,
+				NotFound:   test.notFound,
+				RepoConfig: test.repo,
+			}
+			request := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.accept != "" {
+				request.Header.Set("Accept", test.accept)
+			}
+			response, err := config.notFoundPage(context.Background(), client, test.path, request)
+			if test.want == "" {
+				if errorStatus(err) != http.StatusNotFound {
+					t.Errorf("notFoundPage() error = %v, want not found", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(response.Body)
+			if string(body) != test.want || response.StatusCode != test.status {
+				t.Errorf("notFoundPage() = %d %q, want %d %q", response.StatusCode, body, test.status, test.want)
+			}
+		})
+	}
+}
@@ -5,55 +5,89 @@ import (
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"net/http"
-	"runtime"
 	"strings"
 )
 
-func (p *PageClient) Route(writer http.ResponseWriter, request *http.Request) error {
-	defer func() error {
+func (p *PageClient) Route(writer http.ResponseWriter, request *http.Request) (err error) {
+	recorder := newResponseRecorder(writer)
+	writer = recorder
+	request, state := withRequestState(request)
+	defer p.observe(recorder, request, state)
+	defer func() {
 		//放在匿名函数里,err捕获到错误信息，并且输出
-		err := recover()
-		if err != nil {
-			p.logger.Error("recovered from panic", zap.Any("err", err))
-			var buf [4096]byte
-			n := runtime.Stack(buf[:], false)
-			println(string(buf[:n]))
-			return p.ErrorPages.flushError(errors.New(fmt.Sprintf("%v", err)), request, writer)
+		if r := recover(); r != nil {
+			p.logger.Error("recovered from panic", zap.String("host", request.Host),
+				zap.String("path", request.RequestURI), zap.Any("panic", r), zap.Stack("stacktrace"))
+			err = p.ErrorPages.flushError(errors.New(fmt.Sprintf("%v", r)), request, writer)
 		}
-		return nil
 	}()
-	err := p.RouteExists(writer, request)
+	err = p.RouteExists(writer, request)
 	if err != nil {
-		p.logger.Debug("route exists error", zap.String("host", request.Host),
-			zap.String("path", request.RequestURI), zap.Error(err))
+		if errors.Is(err, ErrorNotMatches) || errorStatus(err) < http.StatusInternalServerError {
+			p.logger.Debug("route exists error", zap.String("host", request.Host),
+				zap.String("path", request.RequestURI), zap.Error(err))
+		} else {
+			p.logger.Error("route exists error", zap.String("host", request.Host),
+				zap.String("path", request.RequestURI), zap.Error(err), StackField(err))
+		}
 		return p.ErrorPages.flushError(err, request, writer)
 	}
 	return err
 }
 
 func (p *PageClient) RouteExists(writer http.ResponseWriter, request *http.Request) error {
-	domain, filePath, err := p.parseDomain(request)
+	if p.Webhook.matches(request) {
+		return p.serveWebhook(writer, request)
+	}
+	if p.Health.matches(request) {
+		return p.serveHealth(writer, request)
+	}
+	if p.handles(request) {
+		if err := p.Limiter.allowClient(writer, request); err != nil {
+			return err
+		}
+	}
+	site, domain, filePath, err := p.parseDomain(request)
 	if err != nil {
 		return err
 	}
-	config, cache, err := p.DomainCache.FetchRepo(p.GiteaConfig, domain)
+	state := getRequestState(request.Context())
+	state.Domain = domain
+	state.Path = filePath
+	if p.Canonical {
+		if location, ok := canonicalLocation(request, domain, filePath); ok {
+			http.Redirect(writer, request, location, http.StatusMovedPermanently)
+			return nil
+		}
+	}
+	config, cache, err := site.DomainCache.FetchRepo(request.Context(), site.GiteaConfig, domain)
 	if err != nil {
 		return err
 	}
+	state.SHA = config.SHA
 	if !config.Exists {
 		return ErrorNotFound
 	}
+	if err = site.authorize(writer, request, domain, config); err != nil {
+		return err
+	}
+	if err = site.checkAccess(writer, request, domain, config, filePath); err != nil {
+		return err
+	}
 	if !cache && len(config.CNAME) > 0 {
 		p.logger.Info("Add CNAME link.", zap.Any("CNAME", config.CNAME))
 		p.DomainAlias.add(domain, config.CNAME...)
 	}
 	// 跳过 30x 重定向
-	if p.AutoRedirect.Enabled &&
+	if site.AutoRedirect.Enabled &&
 		len(config.CNAME) > 0 &&
-		strings.HasPrefix(request.Host, domain.Owner+p.BaseDomain) {
-		http.Redirect(writer, request, p.AutoRedirect.Scheme+"://"+config.CNAME[0], p.AutoRedirect.Code)
+		strings.HasPrefix(request.Host, domain.Owner+site.BaseDomain) {
+		http.Redirect(writer, request, site.AutoRedirect.Scheme+"://"+config.CNAME[0], site.AutoRedirect.Code)
 		return nil
 	}
-	_, err = config.Copy(p.GiteaConfig, filePath, writer, request)
+	if target := site.GiteaConfig.matchProxy(config, filePath); target != nil {
+		return site.proxy(writer, request, target)
+	}
+	_, err = config.Copy(site.GiteaConfig, filePath, writer, request)
 	return err
 }
@@ -0,0 +1,150 @@
+package pages
+
+import (
+	"code.gitea.io/sdk/gitea"
+	"github.com/patrickmn/go-cache"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultBranch = "gh-pages"
+
+// PageSite 一个 Pages 根域名及其对应的 Gitea 服务，缓存按站点隔离
+type PageSite struct {
+	Name          string // 站点标识，主站点为空
+	BaseDomain    string
+	DefaultBranch string
+	GiteaConfig   *GiteaConfig
+	AutoRedirect  *AutoRedirect
+	PrivatePolicy string // 私有仓库访问策略
+	OwnerCache    *OwnerCache
+	DomainCache   *DomainCache
+
+	visitors *cache.Cache // 访客校验结果
+}
+
+func NewPageSite(
+	name string,
+	config *SiteConfig,
+	global *MiddlewareConfig,
+	storage SharedStorage,
+	logger *zap.Logger,
+) (*PageSite, error) {
+	tokens, err := NewTokenSource(append([]string{config.Token}, config.Tokens...), logger)
+	if err != nil {
+		return nil, err
+	}
+	breaker := &Breaker{}
+	httpClient := &http.Client{
+		Transport: &tokenTransport{
+			source:  tokens,
+			base:    http.DefaultTransport,
+			breaker: breaker,
+		},
+	}
+	client, err := gitea.NewClient(config.Server,
+		gitea.SetHTTPClient(httpClient),
+		gitea.SetGiteaVersion(""),
+	)
+	if err != nil {
+		return nil, err
+	}
+	cacheRefresh := time.Duration(global.CacheRefresh)
+	cacheTimeout := time.Duration(global.CacheTimeout)
+	ownerCache := NewOwnerCache(cacheRefresh, cacheTimeout)
+	ownerCache.direct = global.RepoLookup == RepoLookupDirect
+	domainCache := NewDomainCache(cacheRefresh, cacheTimeout)
+	giteaConfig := &GiteaConfig{
+		Server:        config.Server,
+		Tokens:        tokens,
+		Breaker:       breaker,
+		HTTPClient:    httpClient,
+		Client:        client,
+		Logger:        logger,
+		CacheMaxSize:  int(global.CacheMaxSize),
+		CustomHeaders: global.CustomHeaders,
+		Missing:       NewNegativeCache(global.NegativeCache),
+		CleanURLs:     global.CleanURLs,
+		TrailingSlash: global.TrailingSlash,
+		IndexFiles:    global.IndexFiles,
+		NotFoundFile:  global.NotFoundFile,
+		ProxyAllow:    global.ProxyAllow,
+	}
+	if global.SharedCache && storage != nil {
+		prefix := global.SharedPrefix
+		if prefix == "" {
+			prefix = "gitea_pages"
+		}
+		if name != "" {
+			prefix += "/sites/" + name
+		}
+		giteaConfig.Shared = NewSharedCache(storage, prefix, sharedPollInterval, cacheRefresh, logger)
+	}
+	branch := config.DefaultBranch
+	if branch == "" {
+		branch = defaultBranch
+	}
+	redirect := config.AutoRedirect
+	if redirect == nil {
+		redirect = &AutoRedirect{}
+	}
+	return &PageSite{
+		Name:          name,
+		BaseDomain:    "." + strings.Trim(config.Domain, "."),
+		DefaultBranch: branch,
+		GiteaConfig:   giteaConfig,
+		AutoRedirect:  redirect,
+		PrivatePolicy: config.PrivatePolicy,
+		OwnerCache:    &ownerCache,
+		DomainCache:   &domainCache,
+		visitors:      cache.New(cacheTimeout, cacheTimeout*2),
+	}, nil
+}
+
+func (s *PageSite) Close() error {
+	s.OwnerCache.Cache.Flush()
+	s.visitors.Flush()
+	s.GiteaConfig.Missing.Flush()
+	_ = s.DomainCache.Close()
+	return s.GiteaConfig.Shared.Close()
+}
+
+func (s *PageSite) purgeLocal(owner, repo string) {
+	s.OwnerCache.Purge(owner)
+	s.DomainCache.Purge(owner, repo)
+	s.GiteaConfig.Missing.Purge(owner, repo)
+}
+
+// matchSite 按最长的根域名后缀选择站点
+func (p *PageClient) matchSite(host string) *PageSite {
+	var result *PageSite
+	for _, site := range p.Sites {
+		if strings.HasSuffix(host, site.BaseDomain) &&
+			(result == nil || len(site.BaseDomain) > len(result.BaseDomain)) {
+			result = site
+		}
+	}
+	return result
+}
+
+// handles 请求的域名是否由 Pages 提供服务
+func (p *PageClient) handles(request *http.Request) bool {
+	host := strings.Split(request.Host, ":")[0]
+	if p.matchSite(host) != nil {
+		return true
+	}
+	_, exists := p.DomainAlias.Get(host)
+	return exists
+}
+
+// site 根据 PageDomain 记录的站点标识查找站点
+func (p *PageClient) site(name string) *PageSite {
+	for _, site := range p.Sites {
+		if site.Name == name {
+			return site
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package pages
+
+import (
+	"slices"
+	"strings"
+)
+
+// suggestLimit 错误页面最多列出的相近仓库数量
+const suggestLimit = 5
+
+type Suggestion struct {
+	Repo string
+	URL  string // 相对于 owner 域名的地址
+}
+
+// suggest 在 owner 的仓库列表中查找名称相近的仓库，仅包含已缓存且可公开访问的 Pages 仓库
+func (s *PageSite) suggest(owner, repo string) []*Suggestion {
+	raw, found := s.OwnerCache.Get(owner)
+	if !found || repo == "" {
+		return nil
+	}
+	config := raw.(*OwnerConfig)
+	config.mutex.RLock()
+	names := make([]string, 0, len(config.Repos))
+	for name := range config.Repos {
+		names = append(names, name)
+	}
+	config.mutex.RUnlock()
+	target := strings.ToLower(repo)
+	type scored struct {
+		name     string
+		distance int
+	}
+	matches := make([]scored, 0)
+	for _, name := range names {
+		lower := strings.ToLower(name)
+		distance := editDistance(target, lower)
+		contains := len(target) >= 3 && (strings.Contains(lower, target) || strings.Contains(target, lower))
+		if distance > 2 && !contains {
+			continue
+		}
+		// 仓库列表包含私有仓库，仅列出已确认可公开访问的 Pages 仓库
+		cached, _ := s.DomainCache.Get(NewPageDomain(owner, name, s.DefaultBranch).Key())
+		if config, _ := cached.(*DomainConfig); config == nil || !config.Exists ||
+			(config.restricted() && s.PrivatePolicy != "" && s.PrivatePolicy != PrivatePublic) {
+			continue
+		}
+		matches = append(matches, scored{name: name, distance: distance})
+	}
+	slices.SortFunc(matches, func(a, b scored) int {
+		if a.distance != b.distance {
+			return a.distance - b.distance
+		}
+		return strings.Compare(a.name, b.name)
+	})
+	result := make([]*Suggestion, 0, suggestLimit)
+	for _, match := range matches {
+		if len(result) == suggestLimit {
+			break
+		}
+		url := "/" + match.name + "/"
+		if strings.EqualFold(match.name, owner+s.BaseDomain) {
+			url = "/"
+		}
+		result = append(result, &Suggestion{Repo: match.name, URL: url})
+	}
+	return result
+}
+
+// editDistance 两个名称的编辑距离
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,108 @@
+package pages
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"docs", "docs", 0},
+		{"", "docs", 4},
+		{"docs", "", 4},
+		{"docs", "doc", 1},
+		{"docs", "dogs", 1},
+		{"docs", "odcs", 2},
+		{"kitten", "sitting", 3},
+		{"blog", "website", 6},
+	}
+	for _, test := range tests {
+		if got := editDistance(test.a, test.b); got != test.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+		if got := editDistance(test.b, test.a); got != test.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", test.b, test.a, got, test.want)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	owners := NewOwnerCache(time.Minute, time.Minute)
+	domains := NewDomainCache(time.Minute, time.Minute)
+	defer domains.Close()
+	site := &PageSite{
+		BaseDomain:    ".pages.example.com",
+		DefaultBranch: "gh-pages",
+		OwnerCache:    &owners,
+		DomainCache:   &domains,
+	}
+	repos := map[string]*DomainConfig{
+		"docs":                     {Exists: true},
+		"Docs-v2":                  {Exists: true},
+		"dogs":                     {Exists: true},
+		"website":                  {Exists: true},
+		"doc":                      {Exists: false},
+		"docx":                     {Exists: true, Private: true},
+		"dcs":                      {Exists: true, Internal: true, Topics: map[string]bool{publicTopic: true}},
+		"alice.pages.example.com":  {Exists: true},
+		"alice.pages.example.comm": nil, // 未缓存
+	}
+	config := NewOwnerConfig()
+	for name, domain := range repos {
+		config.add(name)
+		if domain != nil {
+			domains.Set(NewPageDomain("alice", name, site.DefaultBranch).Key(), domain, time.Minute)
+		}
+	}
+	owners.Set("alice", config, time.Minute)
+	many := NewOwnerConfig()
+	for _, name := range []string{"app1", "app2", "app3", "app4", "app5", "app6", "app7"} {
+		many.add(name)
+		domains.Set(NewPageDomain("bob", name, site.DefaultBranch).Key(), &DomainConfig{Exists: true}, time.Minute)
+	}
+	owners.Set("bob", many, time.Minute)
+
+	tests := []struct {
+		name   string
+		policy string
+		owner  string
+		repo   string
+		want   []string
+	}{
+		{"distance and containment", PrivatePublic, "alice", "docs", []string{"docs", "dcs", "docx", "dogs", "Docs-v2"}},
+		{"default policy", "", "alice", "docs", []string{"docs", "dcs", "docx", "dogs", "Docs-v2"}},
+		{"restricted hidden", PrivateHide, "alice", "docs", []string{"docs", "dcs", "dogs", "Docs-v2"}},
+		{"restricted with auth", PrivateAuth, "alice", "docs", []string{"docs", "dcs", "dogs", "Docs-v2"}},
+		{"short name without containment", PrivatePublic, "alice", "we", nil},
+		{"containment", PrivatePublic, "alice", "web", []string{"website"}},
+		{"case insensitive", PrivatePublic, "alice", "WEBSITE", []string{"website"}},
+		{"owner root", PrivatePublic, "alice", "alice.pages.example.co", []string{"alice.pages.example.com"}},
+		{"limit", PrivatePublic, "bob", "app", []string{"app1", "app2", "app3", "app4", "app5"}},
+		{"unknown owner", PrivatePublic, "carol", "docs", nil},
+		{"empty repo", PrivatePublic, "alice", "", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			site.PrivatePolicy = test.policy
+			got := make([]string, 0)
+			for _, suggestion := range site.suggest(test.owner, test.repo) {
+				got = append(got, suggestion.Repo)
+				want := "/" + suggestion.Repo + "/"
+				if suggestion.Repo == "alice.pages.example.com" {
+					want = "/"
+				}
+				if suggestion.URL != want {
+					t.Errorf("URL of %s = %q, want %q", suggestion.Repo, suggestion.URL, want)
+				}
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("suggest(%q, %q) = %v, want %v", test.owner, test.repo, got, test.want)
+			}
+		})
+	}
+}
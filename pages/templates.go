@@ -0,0 +1,271 @@
+package pages
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/Masterminds/sprig/v3"
+	"github.com/patrickmn/go-cache"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+	"io"
+	"path"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templatesTopic 使用 text/template 渲染 HTML 页面
+const templatesTopic = "pages-templates"
+
+const (
+	// templateMaxSize 单个页面的渲染结果上限
+	templateMaxSize = 10 << 20
+	// templateMaxAlloc 单次渲染中模板函数累计返回的数据上限
+	templateMaxAlloc = 64 << 20
+	// templateTimeout 超时后不再等待渲染结果
+	templateTimeout = 10 * time.Second
+)
+
+// repoTemplateFuncs 仓库模板可用的 sprig 函数，返回值大小与参数相当，
+// 不包含 repeat、until、indent 等按参数分配内存的函数
+var repoTemplateFuncs = []string{
+	"abbrev", "abbrevboth", "trunc", "trim", "trimAll", "trimSuffix", "trimPrefix",
+	"upper", "lower", "title", "untitle", "substr", "nospace", "initials", "swapcase",
+	"snakecase", "camelcase", "kebabcase", "wrap", "contains", "hasPrefix", "hasSuffix",
+	"quote", "squote", "cat", "plural", "split", "splitList", "splitn", "join", "sortAlpha",
+	"toString", "toStrings", "regexMatch", "regexFind", "regexFindAll", "regexSplit", "regexQuoteMeta",
+	"atoi", "int", "int64", "float64", "add1", "add", "sub", "div", "mod", "mul",
+	"max", "min", "biggest", "ceil", "floor", "round",
+	"default", "empty", "coalesce", "all", "any", "compact", "ternary", "fail",
+	"toJson", "toPrettyJson", "toRawJson", "fromJson",
+	"b64enc", "b64dec", "b32enc", "b32dec", "sha1sum", "sha256sum", "adler32sum",
+	"typeOf", "typeIs", "kindOf", "kindIs", "deepEqual", "base", "dir", "clean", "ext",
+	"tuple", "list", "first", "rest", "last", "initial", "append", "prepend", "concat",
+	"reverse", "uniq", "without", "has", "slice", "chunk",
+	"dict", "get", "set", "unset", "hasKey", "pluck", "keys", "values", "pick", "omit", "dig", "merge",
+	"urlParse", "urlJoin", "semver", "semverCompare",
+}
+
+// templateFormatWidth fmt 会按宽度与精度预先分配内存
+var templateFormatWidth = regexp.MustCompile(`%[^a-zA-Z%]*(\d{4,}|\*)`)
+
+// templateRenders 同一页面同时只渲染一次
+var templateRenders singleflight.Group
+
+// templateBudget 统计模板函数返回的数据量，budget 为 nil 时不限制
+type templateBudget struct {
+	used int
+}
+
+// repoFuncMap 仓库内容不可信，模板只能使用白名单中的函数，不能读取环境变量或访问网络
+func repoFuncMap(budget *templateBudget) template.FuncMap {
+	funcs := sprig.HermeticTxtFuncMap()
+	result := make(template.FuncMap, len(repoTemplateFuncs)+3)
+	for _, name := range repoTemplateFuncs {
+		result[name] = budget.wrap(funcs[name])
+	}
+	result["printf"] = budget.wrap(templatePrintf)
+	result["print"] = budget.wrap(fmt.Sprint)
+	result["println"] = budget.wrap(fmt.Sprintln)
+	return result
+}
+
+func templatePrintf(format string, args ...any) (string, error) {
+	if templateFormatWidth.MatchString(format) {
+		return "", errors.Errorf("printf width or precision too large: %q", format)
+	}
+	return fmt.Sprintf(format, args...), nil
+}
+
+// wrap 超过上限时 panic，text/template 会将其转换为执行错误
+func (b *templateBudget) wrap(fn any) any {
+	if b == nil {
+		return fn
+	}
+	value := reflect.ValueOf(fn)
+	return reflect.MakeFunc(value.Type(), func(args []reflect.Value) []reflect.Value {
+		var results []reflect.Value
+		if value.Type().IsVariadic() {
+			results = value.CallSlice(args)
+		} else {
+			results = value.Call(args)
+		}
+		if len(results) > 0 {
+			b.used += templateDataSize(results[0])
+		}
+		if b.used > templateMaxAlloc {
+			panic(fmt.Sprintf("template functions returned more than %d bytes", templateMaxAlloc))
+		}
+		return results
+	}).Interface()
+}
+
+// templateDataSize 估算返回值的大小，列表与字典按元素数量计算
+func templateDataSize(value reflect.Value) int {
+	switch value.Kind() {
+	case reflect.String:
+		return value.Len()
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return value.Len() * 16
+	case reflect.Interface, reflect.Pointer:
+		if !value.IsNil() {
+			return templateDataSize(value.Elem())
+		}
+	}
+	return 8
+}
+
+// templateWriter 超过大小或超时后停止写入，模板随之终止执行
+type templateWriter struct {
+	ctx context.Context
+	buf bytes.Buffer
+}
+
+func (w *templateWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if w.buf.Len()+len(p) > templateMaxSize {
+		return 0, errors.Errorf("template output exceeds %d bytes", templateMaxSize)
+	}
+	return w.buf.Write(p)
+}
+
+type TemplateMetadata struct {
+	Owner  string
+	Repo   string
+	Branch string
+	SHA    string
+	Date   time.Time // 提交时间
+	Topics []string
+	Path   string
+}
+
+// renderTemplate 渲染 HTML 页面，结果与渲染失败均按提交缓存，超过缓存大小时不缓存结果
+func (receiver *DomainConfig) renderTemplate(
+	ctx context.Context,
+	client *GiteaConfig,
+	source *FakeResponse,
+	filePath string,
+) (*FakeResponse, error) {
+	defer source.Body.Close()
+	key := "template:" + filePath
+	failedKey := "template-error:" + filePath
+	result := receiver.newResponse(client, key)
+	result.ContentType("text/html; charset=utf-8")
+	if cached, _ := receiver.FileCache.Get(key); cached != nil {
+		page := cached.([]byte)
+		result.Body = NewByteBuf(page)
+		result.Length(len(page))
+		result.CacheModeHit()
+		return result, nil
+	}
+	if failed, _ := receiver.FileCache.Get(failedKey); failed != nil {
+		return nil, errors.Wrapf(ErrorInternal, "render page template: %s", failed)
+	}
+	value, err, _ := templateRenders.Do(receiver.tag(key), func() (any, error) {
+		body, err := receiver.executeTemplate(ctx, client, source, filePath)
+		if err != nil {
+			// Gitea 暂时不可用导致 include 失败时下次重试
+			if !errors.Is(err, ErrorBadGateway) && !errors.Is(err, ErrorUnavailable) &&
+				!errors.Is(err, ErrorGatewayTimeout) {
+				receiver.FileCache.Set(failedKey, []byte(err.Error()), cache.DefaultExpiration)
+			}
+			return nil, err
+		}
+		if len(body) <= client.CacheMaxSize {
+			receiver.FileCache.Set(key, body, cache.DefaultExpiration)
+		}
+		return body, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	body := value.([]byte)
+	result.Body = NewByteBuf(body)
+	result.Length(len(body))
+	if len(body) > client.CacheMaxSize {
+		// 超过大小，不缓存
+		result.CacheModeIgnore()
+	} else {
+		result.CacheModeMiss()
+	}
+	return result, nil
+}
+
+// executeTemplate 渲染不随单个请求取消，超时后放弃等待，不输出内容的循环无法中断
+func (receiver *DomainConfig) executeTemplate(
+	ctx context.Context,
+	client *GiteaConfig,
+	source *FakeResponse,
+	filePath string,
+) ([]byte, error) {
+	data, err := io.ReadAll(source.Body)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), templateTimeout)
+	defer cancel()
+	budget := &templateBudget{}
+	tmpl, err := template.New(filePath).
+		Funcs(repoFuncMap(budget)).
+		Funcs(template.FuncMap{"include": budget.wrap(receiver.includeFunc(ctx, client, filePath))}).
+		Parse(string(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse page template")
+	}
+	topics := make([]string, 0, len(receiver.Topics))
+	for topic := range receiver.Topics {
+		topics = append(topics, topic)
+	}
+	slices.Sort(topics)
+	page := &templateWriter{ctx: ctx}
+	done := make(chan error, 1)
+	go func() {
+		done <- tmpl.Execute(page, &TemplateMetadata{
+			Owner:  receiver.PageDomain.Owner,
+			Repo:   receiver.PageDomain.Repo,
+			Branch: receiver.PageDomain.Branch,
+			SHA:    receiver.SHA,
+			Date:   receiver.DATE,
+			Topics: topics,
+			Path:   filePath,
+		})
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		return nil, errors.Wrapf(ErrorInternal, "render page template: not finished within %s", templateTimeout)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "render page template")
+	}
+	return page.buf.Bytes(), nil
+}
+
+// includeFunc 读取仓库内的其他文件，相对路径以当前页面所在目录为准，内容不再渲染
+func (receiver *DomainConfig) includeFunc(ctx context.Context, client *GiteaConfig, filePath string) func(string) (string, error) {
+	return func(name string) (string, error) {
+		if !strings.HasPrefix(name, "/") {
+			name = path.Dir(filePath) + "/" + name
+		}
+		name = path.Clean(name)
+		response, err := receiver.openFile(ctx, client, name)
+		if errors.Is(err, ErrorNotFound) {
+			// 不能让页面本身变为 404
+			return "", errors.Errorf("include %s: file not found", name)
+		} else if err != nil {
+			return "", errors.Wrapf(err, "include %s", name)
+		}
+		defer response.Body.Close()
+		data, err := io.ReadAll(response.Body)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+}
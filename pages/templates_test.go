@@ -0,0 +1,100 @@
+package pages
+
+import (
+	"context"
+	"github.com/Masterminds/sprig/v3"
+	"github.com/patrickmn/go-cache"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func templateSource(text string) *FakeResponse {
+	result := NewFakeResponse()
+	result.Body = NewByteBuf([]byte(text))
+	return result
+}
+
+func TestRepoTemplateFuncs(t *testing.T) {
+	funcs := sprig.HermeticTxtFuncMap()
+	for _, name := range repoTemplateFuncs {
+		if funcs[name] == nil {
+			t.Errorf("%s is not a hermetic sprig function", name)
+		}
+	}
+}
+
+func TestExecuteTemplate(t *testing.T) {
+	// 32 次翻倍，超过模板函数的数据上限
+	doubling := `{{ $s := "xxxxxxxx" }}{{ range splitList "," "` + strings.Repeat("a,", 31) + `a" }}{{ $s = cat $s $s }}{{ end }}`
+	// 拼接出 1MB 的字符串后输出 11 次，超过页面大小上限
+	large := `{{ $s := "x" }}{{ range splitList "," "` + strings.Repeat("a,", 19) + `a" }}{{ $s = cat $s $s }}{{ end }}` +
+		`{{ range splitList "," "` + strings.Repeat("a,", 10) + `a" }}{{ $s }}{{ end }}`
+	tests := []struct {
+		name string
+		text string
+		want string // 为空时期望失败
+	}{
+		{name: "metadata", text: `{{ .Owner }}/{{ .Repo }} {{ .Path }}`, want: "alice/blog /index.html"},
+		{name: "sprig", text: `{{ "hello" | upper | quote }} {{ list 1 2 3 | join "," }}`, want: `"HELLO" 1,2,3`},
+		{name: "printf", text: `{{ printf "%5d|%.2f" 3 1.5 }}`, want: "    3|1.50"},
+		{name: "env", text: `{{ env "HOME" }}`},
+		{name: "expandenv", text: `{{ expandenv "$HOME" }}`},
+		{name: "dns", text: `{{ getHostByName "example.com" }}`},
+		{name: "repeat", text: `{{ repeat 2000000000 "x" }}`},
+		{name: "until", text: `{{ range until 1000000000 }}{{ end }}`},
+		{name: "seq", text: `{{ seq 1 1000000000 }}`},
+		{name: "indent", text: `{{ indent 1000000000 "x" }}`},
+		{name: "printf width", text: `{{ printf "%01000000000d" 1 }}`},
+		{name: "printf star", text: `{{ printf "%*d" 1000000000 1 }}`},
+		{name: "doubling", text: doubling},
+		{name: "output size", text: large},
+		{name: "fail", text: `{{ fail "broken" }}`},
+	}
+	config := &DomainConfig{
+		PageDomain: *NewPageDomain("alice", "blog", "gh-pages"),
+		FileCache:  cache.New(time.Minute, time.Minute),
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := config.executeTemplate(context.Background(), &GiteaConfig{}, templateSource(test.text), "/index.html")
+			if test.want == "" {
+				if err == nil {
+					t.Errorf("executeTemplate() = %d bytes, want error", len(body))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != test.want {
+				t.Errorf("executeTemplate() = %q, want %q", body, test.want)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateCachesFailure(t *testing.T) {
+	config := &DomainConfig{
+		PageDomain: *NewPageDomain("alice", "blog", "gh-pages"),
+		SHA:        "0123456789",
+		FileCache:  cache.New(time.Minute, time.Minute),
+	}
+	client := &GiteaConfig{CacheMaxSize: 1 << 20}
+	if _, err := config.renderTemplate(context.Background(), client, templateSource(`{{ fail "broken" }}`), "/a.html"); err == nil {
+		t.Fatal("renderTemplate() succeeded, want error")
+	}
+	// 同一提交不再重新渲染
+	_, err := config.renderTemplate(context.Background(), client, templateSource(`ok`), "/a.html")
+	if errorStatus(err) != 500 || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("renderTemplate() = %v, want cached failure", err)
+	}
+	response, err := config.renderTemplate(context.Background(), client, templateSource(`ok`), "/b.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(response.Body); string(body) != "ok" {
+		t.Errorf("renderTemplate() = %q, want ok", body)
+	}
+}
@@ -0,0 +1,171 @@
+package pages
+
+import (
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenReloadInterval 检查 token 文件变更的最小间隔
+const tokenReloadInterval = 10 * time.Second
+
+var tokenFilePattern = regexp.MustCompile(`\{file\.([^}]+)}`)
+
+// TokenSource 提供 Gitea token，支持 {env.*}、{file.*} 等 Caddy 占位符，
+// 引用的文件变更后自动重新读取，返回 401 时切换到下一个 token
+type TokenSource struct {
+	entries []*tokenEntry
+	current int
+	checked time.Time
+	mutex   sync.Mutex
+	logger  *zap.Logger
+}
+
+type tokenEntry struct {
+	raw   string
+	value string
+	files map[string]time.Time // 引用的文件及其修改时间
+}
+
+func NewTokenSource(tokens []string, logger *zap.Logger) (*TokenSource, error) {
+	result := &TokenSource{
+		checked: time.Now(),
+		logger:  logger,
+	}
+	for _, raw := range tokens {
+		if raw == "" {
+			continue
+		}
+		entry := &tokenEntry{raw: raw}
+		if err := entry.resolve(); err != nil {
+			return nil, err
+		}
+		result.entries = append(result.entries, entry)
+	}
+	return result, nil
+}
+
+func (e *tokenEntry) resolve() error {
+	files := make(map[string]time.Time)
+	for _, match := range tokenFilePattern.FindAllStringSubmatch(e.raw, -1) {
+		stat, err := os.Stat(match[1])
+		if err != nil {
+			return err
+		}
+		files[match[1]] = stat.ModTime()
+	}
+	value, err := caddy.NewReplacer().ReplaceOrErr(e.raw, true, true)
+	if err != nil {
+		return err
+	}
+	e.value = strings.TrimSpace(value)
+	e.files = files
+	return nil
+}
+
+func (e *tokenEntry) changed() bool {
+	for file, modTime := range e.files {
+		stat, err := os.Stat(file)
+		if err == nil && !stat.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// Len 返回配置的 token 数量
+func (s *TokenSource) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.entries)
+}
+
+// Token 返回当前使用的 token 及其序号
+func (s *TokenSource) Token() (string, int) {
+	if s.Len() == 0 {
+		return "", -1
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if time.Since(s.checked) > tokenReloadInterval {
+		s.checked = time.Now()
+		for _, entry := range s.entries {
+			if !entry.changed() {
+				continue
+			}
+			if err := entry.resolve(); err != nil {
+				s.logger.Warn("failed to reload gitea token", zap.Error(err))
+			} else {
+				s.logger.Info("gitea token reloaded")
+			}
+		}
+	}
+	return s.entries[s.current].value, s.current
+}
+
+// failover 当前 token 失效后切换到下一个
+func (s *TokenSource) failover(index int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.current == index {
+		s.current = (s.current + 1) % len(s.entries)
+		s.logger.Warn("gitea token rejected, switching to next token", zap.Int("index", s.current))
+	}
+}
+
+// tokenTransport 为 Gitea 请求添加 token，并在 401 时使用下一个 token 重试
+type tokenTransport struct {
+	source  *TokenSource
+	base    http.RoundTripper
+	breaker *Breaker
+}
+
+func (t *tokenTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if err := t.breaker.allow(); err != nil {
+		return nil, err
+	}
+	resp, err := t.roundTrip(request)
+	if request.Context().Err() == nil {
+		// 访客取消的请求不计入
+		t.breaker.record(resp, err)
+	}
+	return resp, err
+}
+
+func (t *tokenTransport) roundTrip(request *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		token, index := t.source.Token()
+		req := request.Clone(request.Context())
+		if attempt > 0 && request.Body != nil {
+			body, err := request.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "token "+token)
+		}
+		// SDK 的请求同样需要传递链路信息
+		injectTrace(req.Context(), req)
+		resp, err := t.base.RoundTrip(req)
+		// 无法重放请求体时不重试
+		if err != nil || resp.StatusCode != http.StatusUnauthorized ||
+			attempt+1 >= t.source.Len() || (request.Body != nil && request.GetBody == nil) {
+			return resp, err
+		}
+		_ = resp.Body.Close()
+		t.source.failover(index)
+	}
+}
+
+// isPlaceholder 占位符本身不是密钥，日志中保留原样
+func isPlaceholder(token string) bool {
+	return strings.HasPrefix(token, "{") && strings.HasSuffix(token, "}")
+}
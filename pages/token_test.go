@@ -0,0 +1,169 @@
+package pages
+
+import (
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// tokenServer 记录收到的 token，rejected 中的 token 返回对应状态码
+func tokenServer(t *testing.T, rejected map[string]int) (*httptest.Server, *[]string) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		token := request.Header.Get("Authorization")
+		received = append(received, token)
+		if code, ok := rejected[token]; ok {
+			writer.WriteHeader(code)
+			return
+		}
+		writer.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server, &received
+}
+
+func TestTokenTransportFailover(t *testing.T) {
+	tests := []struct {
+		name     string
+		tokens   []string
+		rejected map[string]int
+		status   int
+		received []string
+		current  int
+	}{
+		{
+			name:     "switch on 401",
+			tokens:   []string{"a", "b"},
+			rejected: map[string]int{"token a": http.StatusUnauthorized},
+			status:   http.StatusOK,
+			received: []string{"token a", "token b"},
+			current:  1,
+		},
+		{
+			name:     "all rejected",
+			tokens:   []string{"a", "b"},
+			rejected: map[string]int{"token a": http.StatusUnauthorized, "token b": http.StatusUnauthorized},
+			status:   http.StatusUnauthorized,
+			received: []string{"token a", "token b"},
+			current:  1,
+		},
+		{
+			name:     "single token",
+			tokens:   []string{"a"},
+			rejected: map[string]int{"token a": http.StatusUnauthorized},
+			status:   http.StatusUnauthorized,
+			received: []string{"token a"},
+			current:  0,
+		},
+		{
+			name:     "403 keeps token",
+			tokens:   []string{"a", "b"},
+			rejected: map[string]int{"token a": http.StatusForbidden},
+			status:   http.StatusForbidden,
+			received: []string{"token a"},
+			current:  0,
+		},
+		{
+			name:     "429 keeps token",
+			tokens:   []string{"a", "b"},
+			rejected: map[string]int{"token a": http.StatusTooManyRequests},
+			status:   http.StatusTooManyRequests,
+			received: []string{"token a"},
+			current:  0,
+		},
+		{
+			name:     "no token",
+			tokens:   []string{""},
+			status:   http.StatusOK,
+			received: []string{""},
+			current:  -1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, received := tokenServer(t, test.rejected)
+			source, err := NewTokenSource(test.tokens, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &http.Client{Transport: &tokenTransport{source: source, base: http.DefaultTransport}}
+			resp, err := client.Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != test.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, test.status)
+			}
+			if !slices.Equal(*received, test.received) {
+				t.Errorf("received %q, want %q", *received, test.received)
+			}
+			if _, current := source.Token(); current != test.current {
+				t.Errorf("current token = %d, want %d", current, test.current)
+			}
+		})
+	}
+}
+
+func TestTokenSourceReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(file, []byte("first\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	source, err := NewTokenSource([]string{"{file." + file + "}"}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := source.Token(); token != "first" {
+		t.Fatalf("token = %q, want first", token)
+	}
+	if err = os.WriteFile(file, []byte("second\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Now().Add(time.Minute)
+	if err = os.Chtimes(file, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := source.Token(); token != "first" {
+		t.Errorf("token reloaded before %v", tokenReloadInterval)
+	}
+	source.checked = time.Now().Add(-2 * tokenReloadInterval)
+	if token, _ := source.Token(); token != "second" {
+		t.Errorf("token = %q, want second", token)
+	}
+	// 读取失败时沿用旧值
+	if err = os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	source.checked = time.Now().Add(-2 * tokenReloadInterval)
+	if token, _ := source.Token(); token != "second" {
+		t.Errorf("token = %q after file removal, want second", token)
+	}
+}
+
+func TestMiddlewareConfigRedacted(t *testing.T) {
+	config := &MiddlewareConfig{
+		Token:   "secret",
+		Tokens:  []string{"{env.GITEA_TOKEN}", "backup", ""},
+		Webhook: &Webhook{Path: "/webhook", Secret: "hook"},
+		Sites:   []*SiteConfig{{Domain: "example.com", Token: "site", Tokens: []string{"{file./run/token}"}}},
+	}
+	result := config.redacted()
+	if result.Token != redactedValue || !slices.Equal(result.Tokens, []string{"{env.GITEA_TOKEN}", redactedValue, ""}) {
+		t.Errorf("tokens = %q %q", result.Token, result.Tokens)
+	}
+	if result.Webhook.Secret != redactedValue || result.Webhook.Path != "/webhook" {
+		t.Errorf("webhook = %+v", result.Webhook)
+	}
+	if result.Sites[0].Token != redactedValue || result.Sites[0].Tokens[0] != "{file./run/token}" {
+		t.Errorf("site tokens = %q %q", result.Sites[0].Token, result.Sites[0].Tokens)
+	}
+	if config.Token != "secret" || config.Tokens[1] != "backup" || config.Webhook.Secret != "hook" || config.Sites[0].Token != "site" {
+		t.Error("redacted must not modify the original config")
+	}
+}
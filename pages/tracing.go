@@ -0,0 +1,64 @@
+package pages
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"time"
+)
+
+const tracerName = "github.com/d7z-project/caddy-gitea-pages"
+
+var tracePropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// startSpan 优先使用 Caddy tracing 处理器在请求中创建的 TracerProvider
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	provider := otel.GetTracerProvider()
+	if parent := trace.SpanFromContext(ctx); parent.SpanContext().IsValid() {
+		provider = parent.TracerProvider()
+	}
+	return provider.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan 结束 span 并记录错误
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func domainAttributes(domain *PageDomain) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("pages.owner", domain.Owner),
+		attribute.String("pages.repo", domain.Repo),
+		attribute.String("pages.branch", domain.Branch),
+	}
+}
+
+// injectTrace 将链路信息传递给 Gitea
+func injectTrace(ctx context.Context, request *http.Request) {
+	tracePropagator.Inject(ctx, propagation.HeaderCarrier(request.Header))
+}
+
+// origin 记录一次 Gitea 调用的链路与指标，返回的函数传入响应状态码，0 表示请求未完成
+func (c *GiteaConfig) origin(ctx context.Context, endpoint string) (context.Context, func(code int)) {
+	start := time.Now()
+	ctx, span := startSpan(ctx, "gitea "+endpoint, attribute.String("gitea.endpoint", endpoint))
+	return ctx, func(code int) {
+		if code > 0 {
+			span.SetAttributes(attribute.Int("http.response.status_code", code))
+		}
+		if code == 0 || code >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(code))
+		}
+		span.End()
+		c.Metrics.origin(endpoint, start, code)
+		getRequestState(ctx).Origin += time.Since(start)
+	}
+}
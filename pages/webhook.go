@@ -0,0 +1,75 @@
+package pages
+
+import (
+	"code.gitea.io/sdk/gitea"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+)
+
+type Webhook struct {
+	Path   string `json:"path"`
+	Secret string `json:"secret"`
+}
+
+type webhookPayload struct {
+	Repository *gitea.Repository `json:"repository"`
+}
+
+func (w *Webhook) matches(request *http.Request) bool {
+	return w != nil && w.Path != "" && request.URL.Path == w.Path
+}
+
+// verify 校验 Gitea 的 X-Gitea-Signature 签名，未配置密钥时拒绝所有请求
+func (w *Webhook) verify(request *http.Request, body []byte) bool {
+	if w.Secret == "" {
+		return false
+	}
+	signature, err := hex.DecodeString(request.Header.Get("X-Gitea-Signature"))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write(body)
+	return hmac.Equal(signature, mac.Sum(nil))
+}
+
+// serveWebhook 处理 Gitea 推送事件，清理对应仓库在所有节点的缓存
+func (p *PageClient) serveWebhook(writer http.ResponseWriter, request *http.Request) error {
+	if request.Method != http.MethodPost {
+		writer.Header().Set("Allow", http.MethodPost)
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(request.Body, 10*1024*1024))
+	if err != nil {
+		return err
+	}
+	if !p.Webhook.verify(request, body) {
+		p.logger.Warn("invalid webhook signature", zap.String("remote", request.RemoteAddr))
+		writer.WriteHeader(http.StatusForbidden)
+		return nil
+	}
+	var payload webhookPayload
+	if err = json.Unmarshal(body, &payload); err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return nil
+	}
+	if payload.Repository == nil || payload.Repository.Owner == nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return nil
+	}
+	owner := payload.Repository.Owner.UserName
+	repo := payload.Repository.Name
+	if err = p.Purge(owner, repo); err != nil {
+		return errors.Wrap(err, "purge failed")
+	}
+	p.logger.Info("purged by webhook", zap.String("owner", owner), zap.String("repo", repo))
+	writer.WriteHeader(http.StatusNoContent)
+	return nil
+}
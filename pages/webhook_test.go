@@ -0,0 +1,43 @@
+package pages
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookVerify(t *testing.T) {
+	body := []byte(`{"repository":{"name":"blog","owner":{"login":"alice"}}}`)
+	sign := func(secret string, data []byte) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(data)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		want      bool
+	}{
+		{"valid", "secret", sign("secret", body), true},
+		{"wrong secret", "secret", sign("other", body), false},
+		{"modified body", "secret", sign("secret", append(body, ' ')), false},
+		{"missing signature", "secret", "", false},
+		{"not hex", "secret", "zz", false},
+		{"truncated", "secret", sign("secret", body)[:32], false},
+		{"no secret configured", "", sign("", body), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest("POST", "/webhook", nil)
+			if test.signature != "" {
+				request.Header.Set("X-Gitea-Signature", test.signature)
+			}
+			if got := (&Webhook{Path: "/webhook", Secret: test.secret}).verify(request, body); got != test.want {
+				t.Errorf("verify() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}